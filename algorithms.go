@@ -0,0 +1,81 @@
+package bitbucket
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"sync"
+)
+
+// Names of the signature algorithms registered by default. The name is the prefix of the X-Hub-Signature header,
+// e.g. "sha256=...".
+const (
+	// AlgorithmSHA1 is a legacy algorithm used by some proxies. It should only be allowed when it is required.
+	AlgorithmSHA1 = "sha1"
+	// AlgorithmSHA256 is the algorithm used by Bitbucket Server and Data Center
+	AlgorithmSHA256 = "sha256"
+	// AlgorithmSHA512 is a stronger algorithm used by some relays
+	AlgorithmSHA512 = "sha512"
+)
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]func() hash.Hash{
+		AlgorithmSHA1:   sha1.New,
+		AlgorithmSHA256: sha256.New,
+		AlgorithmSHA512: sha512.New,
+	}
+)
+
+// RegisterSignatureAlgorithm registers a hash function for signatures prefixed with name, e.g. "sha384". Registering
+// an algorithm does not allow it; use WithSignatureAlgorithms to accept signatures made with it.
+func RegisterSignatureAlgorithm(name string, fn func() hash.Hash) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	algorithms[name] = fn
+}
+
+// signatureAlgorithm returns the hash function registered for name
+func signatureAlgorithm(name string) (func() hash.Hash, bool) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	fn, ok := algorithms[name]
+	return fn, ok
+}
+
+// WithSignatureAlgorithms sets the signature algorithms that are accepted. Only sha256 is accepted by default, which
+// is the algorithm used by Bitbucket Server and Data Center. Signatures made with any other algorithm are rejected, so
+// calling it without names rejects every signed request.
+//
+// Example: accept legacy sha1 signatures from a proxy
+//
+//	webhook.New(WithSecret("WEBHOOK_SECRET"), WithSignatureAlgorithms(AlgorithmSHA256, AlgorithmSHA1))
+func WithSignatureAlgorithms(names ...string) Option {
+	return func(w *Webhook) {
+		w.algorithms = append([]string{}, names...)
+	}
+}
+
+// allowedAlgorithms returns the names of the accepted signature algorithms. The algorithms are only nil when
+// WithSignatureAlgorithms was not used.
+func (hook *Webhook) allowedAlgorithms() []string {
+	if hook.algorithms == nil {
+		return []string{AlgorithmSHA256}
+	}
+
+	return hook.algorithms
+}
+
+// allowsAlgorithm reports whether signatures made with the algorithm name are accepted
+func (hook *Webhook) allowsAlgorithm(name string) bool {
+	for _, a := range hook.allowedAlgorithms() {
+		if a == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package bitbucket
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+func TestSignatureAlgorithms(t *testing.T) {
+	payload := []byte(`{"eventKey": "pr:opened"}`)
+	RegisterSignatureAlgorithm("md5", md5.New)
+
+	tc := []struct {
+		Name        string
+		Allowed     []string
+		Signature   string
+		ExpectedErr bool
+	}{
+		{Name: "sha256 allowed by default", Signature: signature("secret", string(payload))},
+		{Name: "sha512 rejected by default", Signature: sign(sha512.New, "sha512", payload), ExpectedErr: true},
+		{Name: "sha1 rejected by default", Signature: sign(sha1.New, "sha1", payload), ExpectedErr: true},
+		{Name: "sha512 allowed", Allowed: []string{AlgorithmSHA512}, Signature: sign(sha512.New, "sha512", payload)},
+		{Name: "sha1 allowed", Allowed: []string{AlgorithmSHA256, AlgorithmSHA1}, Signature: sign(sha1.New, "sha1", payload)},
+		{Name: "sha256 not in allow-list", Allowed: []string{AlgorithmSHA512}, Signature: signature("secret", string(payload)), ExpectedErr: true},
+		{Name: "registered algorithm allowed", Allowed: []string{"md5"}, Signature: sign(md5.New, "md5", payload)},
+		{Name: "unregistered algorithm", Allowed: []string{"sha3"}, Signature: "sha3=00", ExpectedErr: true},
+		{Name: "no algorithms allowed", Allowed: []string{}, Signature: signature("secret", string(payload)), ExpectedErr: true},
+		{Name: "wrong algorithm for prefix", Allowed: []string{AlgorithmSHA512}, Signature: "sha512=" + sign(sha1.New, "", payload)[1:], ExpectedErr: true},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		options := []Option{}
+		if tt.Allowed != nil {
+			options = append(options, WithSignatureAlgorithms(tt.Allowed...))
+		}
+
+		err := New(options...).VerifySignature(payload, tt.Signature, "secret")
+		if tt.ExpectedErr && err == nil || !tt.ExpectedErr && err != nil {
+			t.Errorf("%s: Expected error: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
+
+func TestSignatureAlgorithmsCopied(t *testing.T) {
+	payload := []byte(`{"eventKey": "pr:opened"}`)
+
+	fmt.Println("Test: changing the slice passed to WithSignatureAlgorithms")
+	allowed := []string{AlgorithmSHA256}
+	hook := New(WithSignatureAlgorithms(allowed...))
+	allowed[0] = AlgorithmSHA1
+
+	if err := hook.VerifySignature(payload, signature("secret", string(payload)), "secret"); err != nil {
+		t.Errorf("Expected: %v, Got: %v", nil, err)
+	}
+}
+
+func sign(fn func() hash.Hash, prefix string, payload []byte) string {
+	mac := hmac.New(fn, []byte("secret"))
+	mac.Write(payload)
+	return prefix + "=" + hex.EncodeToString(mac.Sum(nil))
+}
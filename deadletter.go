@@ -0,0 +1,238 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeadLetter is a delivery whose handlers still failed after every retry
+type DeadLetter struct {
+	// ID identifies the dead letter in its sink
+	ID string `json:"id"`
+
+	// EventKey is the value of the X-Event-Key header
+	EventKey EventKey `json:"eventKey"`
+
+	// RequestID is the value of the X-Request-Id header
+	RequestID string `json:"requestId"`
+
+	// Header holds the headers of the request, with credentials redacted
+	Header http.Header `json:"header"`
+
+	// Payload is the request body as held in Delivery.Raw, decompressed when WithGzip is set. It is encoded as base64,
+	// so its bytes are kept exactly and its signature can be checked again.
+	Payload []byte `json:"payload,omitempty"`
+
+	// Error is the error returned by the last attempt
+	Error string `json:"error"`
+
+	// Attempts is the number of times the handlers were called
+	Attempts int `json:"attempts"`
+
+	// ReceivedAt is the time the request was received
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// FailedAt is the time of the last attempt
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// DeadLetterSink stores the deliveries that a Dispatcher could not handle, so they can be inspected and re-run
+type DeadLetterSink interface {
+	Put(ctx context.Context, letter DeadLetter) error
+}
+
+// WithDeadLetterSink stores deliveries whose handlers failed on every attempt in sink
+func WithDeadLetterSink(sink DeadLetterSink) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.deadLetters = sink
+	}
+}
+
+// newDeadLetter creates the dead letter of a delivery
+func newDeadLetter(d *Delivery, err error, attempts int) DeadLetter {
+	var header http.Header
+	if d.Header != nil {
+		header = redactHeader(d.Header)
+	}
+
+	return DeadLetter{
+		EventKey:   d.EventKey,
+		RequestID:  d.RequestID,
+		Header:     header,
+		Payload:    d.Raw,
+		Error:      err.Error(),
+		Attempts:   attempts,
+		ReceivedAt: d.ReceivedAt,
+		FailedAt:   time.Now(),
+	}
+}
+
+// Redeliver decodes the payload of a dead letter and calls the handlers registered for its event key. The signature
+// of the payload is not verified again, so dead letters must only be read from a trusted store.
+func (hook *Webhook) Redeliver(ctx context.Context, letter DeadLetter) error {
+	event, err := decodeStoredEvent(letter.EventKey, letter.Payload)
+	if err != nil {
+		return err
+	}
+
+	delivery := &Delivery{
+		Event:      event,
+		Raw:        letter.Payload,
+		EventKey:   letter.EventKey,
+		RequestID:  letter.RequestID,
+		UserAgent:  letter.Header.Get("User-Agent"),
+		Header:     letter.Header,
+		ReceivedAt: letter.ReceivedAt,
+	}
+
+	return hook.dispatch(contextWithDelivery(ctx, delivery), delivery)
+}
+
+// decodeStoredEvent decodes a payload that was verified when it was received
+func decodeStoredEvent(key EventKey, payload []byte) (Event, error) {
+	if key == EventDiagnosticsPing {
+		return DiagnosticPingEvent{Test: true}, nil
+	}
+
+	event, err := decodeEvent(key, payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode '%s' payload: %w", key, err)
+	}
+
+	return event, nil
+}
+
+// FileDeadLetterSink is a DeadLetterSink that writes each dead letter to a JSON file in a directory
+type FileDeadLetterSink struct {
+	dir string
+}
+
+// NewFileDeadLetterSink creates a FileDeadLetterSink that writes dead letters to dir, creating it when it does not exist
+func NewFileDeadLetterSink(dir string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create dead letter directory: %w", err)
+	}
+
+	return &FileDeadLetterSink{dir: dir}, nil
+}
+
+// Put implements DeadLetterSink. The ID of the letter is set when it is empty.
+func (s *FileDeadLetterSink) Put(ctx context.Context, letter DeadLetter) error {
+	if letter.ID == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return err
+		}
+		letter.ID = fmt.Sprintf("%d-%s", letter.FailedAt.UnixNano(), hex.EncodeToString(suffix))
+	}
+	if !validPathName(letter.ID) {
+		return fmt.Errorf("invalid dead letter ID '%s'", letter.ID)
+	}
+
+	data, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a partially written letter is never listed
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, letter.ID+".json")); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the dead letters in the directory, oldest first
+func (s *FileDeadLetterSink) List() ([]DeadLetter, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead letters: %w", err)
+	}
+
+	var letters []DeadLetter
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("could not read dead letter: %w", err)
+		}
+
+		var letter DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return nil, fmt.Errorf("could not read dead letter '%s': %w", name, err)
+		}
+		letter.ID = strings.TrimSuffix(name, ".json")
+		letters = append(letters, letter)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+
+	return letters, nil
+}
+
+// Remove deletes a dead letter
+func (s *FileDeadLetterSink) Remove(id string) error {
+	if !validPathName(id) {
+		return fmt.Errorf("invalid dead letter ID '%s'", id)
+	}
+
+	return os.Remove(filepath.Join(s.dir, id+".json"))
+}
+
+// Rerun redelivers every dead letter to hook and removes the letters whose handlers succeed. It returns the number of
+// letters that succeeded, and stops at the first error that is not returned by a handler.
+func (s *FileDeadLetterSink) Rerun(ctx context.Context, hook *Webhook) (int, error) {
+	letters, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	succeeded := 0
+	for _, letter := range letters {
+		if err := ctx.Err(); err != nil {
+			return succeeded, err
+		}
+
+		if err := hook.Redeliver(ctx, letter); err != nil {
+			hook.logger.Warn("dead letter failed again", "event_key", letter.EventKey, "request_id", letter.RequestID, "dead_letter_id", letter.ID, "error", err)
+			continue
+		}
+
+		if err := s.Remove(letter.ID); err != nil {
+			return succeeded, fmt.Errorf("could not remove dead letter: %w", err)
+		}
+		succeeded++
+	}
+
+	return succeeded, nil
+}
@@ -0,0 +1,117 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFileDeadLetterSink(t *testing.T) {
+	ctx := context.Background()
+
+	sink, err := NewFileDeadLetterSink(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Hub-Signature", "sha256=abcd")
+	header.Set("User-Agent", "Bitbucket")
+
+	deliveries := []*Delivery{
+		{EventKey: EventPullRequestOpened, RequestID: "1", Raw: []byte(`{"eventKey": "pr:opened", "pullRequest": {"id": 1}}`), Header: header},
+		{EventKey: EventPullRequestOpened, RequestID: "2", Raw: []byte(`{"eventKey": "pr:opened", "pullRequest": {"id": 2}}`), Header: header},
+		{EventKey: EventDiagnosticsPing, RequestID: "3", Header: header},
+	}
+	for i, d := range deliveries {
+		letter := newDeadLetter(d, errors.New("build server unavailable"), 3)
+		letter.FailedAt = time.Unix(int64(i), 0)
+		if err := sink.Put(ctx, letter); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	letters, err := sink.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fmt.Println("Test: list dead letters")
+	if len(letters) != 3 {
+		t.Fatalf("Expected: %d, Got: %d", 3, len(letters))
+	}
+	if letters[0].RequestID != "1" || letters[0].Attempts != 3 || letters[0].Error != "build server unavailable" {
+		t.Errorf("unexpected dead letter: %+v", letters[0])
+	}
+	if letters[0].Header.Get("X-Hub-Signature") != "REDACTED" {
+		t.Errorf("Expected the signature to be redacted, Got: %s", letters[0].Header.Get("X-Hub-Signature"))
+	}
+
+	fmt.Println("Test: rerun dead letters")
+	var handled []uint64
+	pings := 0
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		handled = append(handled, pl.PullRequest.ID)
+		if pl.PullRequest.ID == 2 {
+			return errors.New("still failing")
+		}
+		return nil
+	})
+	hook.OnDiagnosticsPing(func(ctx context.Context, pl DiagnosticPingEvent) error {
+		if d, ok := DeliveryFromContext(ctx); !ok || d.RequestID != "3" {
+			t.Errorf("Expected the delivery of the dead letter in the handler context")
+		}
+		pings++
+		return nil
+	})
+
+	succeeded, err := sink.Rerun(ctx, hook)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if succeeded != 2 {
+		t.Errorf("Expected: %d, Got: %d", 2, succeeded)
+	}
+	if fmt.Sprint(handled) != "[1 2]" || pings != 1 {
+		t.Errorf("Expected handlers to be called for every dead letter, Got: %v and %d pings", handled, pings)
+	}
+
+	letters, err = sink.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(letters) != 1 || letters[0].RequestID != "2" {
+		t.Errorf("Expected only the failing dead letter to remain, Got: %+v", letters)
+	}
+
+	fmt.Println("Test: invalid dead letter ID")
+	if err := sink.Remove("../secrets"); err == nil {
+		t.Errorf("Expected an error for an invalid ID")
+	}
+}
+
+func TestFileDeadLetterSinkKeepsPayload(t *testing.T) {
+	sink, err := NewFileDeadLetterSink(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload := []byte("{\"eventKey\": \"pr:opened\",\n\t\"pullRequest\": {\"title\": \"a < b & c\"}}\n")
+	if err := sink.Put(context.Background(), newDeadLetter(&Delivery{EventKey: EventPullRequestOpened, Raw: payload}, errors.New("failed"), 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fmt.Println("Test: payload is kept byte for byte")
+	letters, err := sink.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(letters) != 1 || !bytes.Equal(letters[0].Payload, payload) {
+		t.Errorf("Expected: %q, Got: %+v", payload, letters)
+	}
+}
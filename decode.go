@@ -0,0 +1,137 @@
+package bitbucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields returns the JSON paths of payload that are not mapped by a field of t. Array elements are written
+// as "[]" so that a field missing from every element of an array is only reported once, e.g.
+// "pullRequest.reviewers[].user.avatarUrl". The returned paths are sorted.
+func unknownFields(payload []byte, t reflect.Type) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	walkUnknownFields(v, t, "", seen)
+
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func walkUnknownFields(v interface{}, t reflect.Type, path string, unknown map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types that decode themselves, such as Timestamp and the enum types, are treated as leaves
+	if reflect.PtrTo(t).Implements(unmarshalerType) || t.Implements(unmarshalerType) {
+		return
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for name, child := range value {
+				field, ok := lookupField(fields, name)
+				if !ok {
+					unknown[joinPath(path, name)] = true
+					continue
+				}
+				walkUnknownFields(child, field, joinPath(path, name), unknown)
+			}
+		case reflect.Map:
+			for name, child := range value {
+				walkUnknownFields(child, t.Elem(), joinPath(path, name), unknown)
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for _, child := range value {
+			walkUnknownFields(child, t.Elem(), path+"[]", unknown)
+		}
+	}
+}
+
+// jsonFields returns the JSON field names of struct type t, including the fields promoted from untagged embedded
+// structs, mapped to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, typ := range jsonFields(ft) {
+					if _, ok := fields[n]; !ok {
+						fields[n] = typ
+					}
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// lookupField finds a field by name, falling back to the case-insensitive match used by encoding/json
+func lookupField(fields map[string]reflect.Type, name string) (reflect.Type, bool) {
+	if t, ok := fields[name]; ok {
+		return t, true
+	}
+
+	for n, t := range fields {
+		if strings.EqualFold(n, name) {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
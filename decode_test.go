@@ -0,0 +1,119 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	payload := `{
+		"eventKey": "pr:opened",
+		"date": "2017-09-19T09:58:11+1000",
+		"newTopLevel": true,
+		"actor": {"name": "admin", "avatarUrl": "/avatar.png"},
+		"pullRequest": {
+			"id": 1,
+			"state": "OPEN",
+			"reviewers": [
+				{"user": {"name": "a", "timeZone": "UTC"}, "role": "REVIEWER"},
+				{"user": {"name": "b", "timeZone": "UTC"}, "role": "REVIEWER"}
+			],
+			"properties": {"mergeResult": {"outcome": "CLEAN", "vetoes": []}}
+		}
+	}`
+
+	paths, err := unknownFields([]byte(payload), reflect.TypeOf(PullRequestOpenedPayload{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"actor.avatarUrl",
+		"newTopLevel",
+		"pullRequest.properties.mergeResult.vetoes",
+		"pullRequest.reviewers[].user.timeZone",
+	}
+
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, paths)
+	}
+}
+
+func TestStrictDecoding(t *testing.T) {
+	body := `{"eventKey": "pr:opened", "pullRequest": {"id": 1, "newField": 1}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Event-Key", "pr:opened")
+
+	_, err := New(WithStrictDecoding()).Parse(req)
+	if !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("Expected: %v, Got: %v", ErrUnknownField, err)
+	}
+
+	if !strings.Contains(err.Error(), "pullRequest.newField") {
+		t.Errorf("Expected the error to contain the unknown path, Got: %v", err)
+	}
+}
+
+func TestUnknownFieldReporter(t *testing.T) {
+	body := `{"eventKey": "pr:opened", "pullRequest": {"id": 1, "newField": 1}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Event-Key", "pr:opened")
+
+	var reported []string
+	hook := New(WithUnknownFieldReporter(func(event EventKey, path string) {
+		reported = append(reported, string(event)+" "+path)
+	}))
+
+	event, err := hook.Parse(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := event.(PullRequestOpenedPayload); !ok {
+		t.Errorf("Expected: PullRequestOpenedPayload, Got: %T", event)
+	}
+
+	if len(reported) != 1 || reported[0] != "pr:opened pullRequest.newField" {
+		t.Errorf("Expected: [pr:opened pullRequest.newField], Got: %v", reported)
+	}
+}
+
+// TestFixturesAreFullyMapped makes sure the payload types cover every field of the fixtures in testdata
+func TestFixturesAreFullyMapped(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		fmt.Println("Test:", fixture)
+		body, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatalf("could not read fixture: %v", err)
+		}
+
+		var key struct {
+			EventKey string `json:"eventKey"`
+		}
+		if err := json.Unmarshal(body, &key); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		req.Header.Set("X-Event-Key", key.EventKey)
+
+		if _, err := New(WithStrictDecoding()).Parse(req); err != nil {
+			t.Errorf("%s: %v", fixture, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Delivery is a single Bitbucket Webhook request. It bundles the parsed event with the raw body and the HTTP metadata
+// that were received, which can be stored for auditing.
+type Delivery struct {
+	// Event is the parsed payload
+	Event Event
+
+	// Raw is the request body as it was received. When WithGzip is set it holds the decompressed body, which is the
+	// payload the signature was computed over.
+	Raw json.RawMessage
+
+	// EventKey is the value of the X-Event-Key header
+	EventKey EventKey
+
+	// RequestID is the value of the X-Request-Id header, which Bitbucket sets to a unique ID for each delivery
+	RequestID string
+
+	// UserAgent is the value of the User-Agent header
+	UserAgent string
+
+	// Signature is the value of the X-Hub-Signature header
+	Signature string
+
+	// SecretID is the ID of the secret that validated the signature. It is empty when the signature was not validated.
+	SecretID string
+
+	// Header holds a copy of all headers of the request
+	Header http.Header
+
+	// ReceivedAt is the time the request was received
+	ReceivedAt time.Time
+}
+
+func newDelivery(req *http.Request, event Event, payload []byte, receivedAt time.Time) *Delivery {
+	return &Delivery{
+		Event:      event,
+		Raw:        json.RawMessage(payload),
+		EventKey:   EventKey(req.Header.Get("X-Event-Key")),
+		RequestID:  req.Header.Get("X-Request-Id"),
+		UserAgent:  req.Header.Get("User-Agent"),
+		Signature:  req.Header.Get("X-Hub-Signature"),
+		Header:     req.Header.Clone(),
+		ReceivedAt: receivedAt,
+	}
+}
+
+type deliveryContextKey struct{}
+
+// contextWithDelivery returns a copy of ctx that carries d
+func contextWithDelivery(ctx context.Context, d *Delivery) context.Context {
+	return context.WithValue(ctx, deliveryContextKey{}, d)
+}
+
+// DeliveryFromContext returns the Delivery being handled. Handlers called by ServeHTTP receive a context that carries
+// the Delivery of the request.
+func DeliveryFromContext(ctx context.Context) (*Delivery, bool) {
+	d, ok := ctx.Value(deliveryContextKey{}).(*Delivery)
+	return d, ok
+}
@@ -0,0 +1,76 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseDelivery(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/pr_merged.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("X-Event-Key", "pr:merged")
+	req.Header.Set("X-Request-Id", "0b5bb1ef-6b4c-4d9e-9c3a-5b1c2f6a7d8e")
+	req.Header.Set("User-Agent", "Atlassian HttpClient 1.1.0 / Bitbucket-7.21.0 (7021000) / Default")
+
+	d, err := New().ParseDelivery(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(d.Raw, body) {
+		t.Errorf("Expected the raw payload to match the request body")
+	}
+	if _, ok := d.Event.(PullRequestMergedPayload); !ok {
+		t.Errorf("Expected: PullRequestMergedPayload, Got: %T", d.Event)
+	}
+	if d.EventKey != EventPullRequestMerged {
+		t.Errorf("Expected: %s, Got: %s", EventPullRequestMerged, d.EventKey)
+	}
+	if d.RequestID != "0b5bb1ef-6b4c-4d9e-9c3a-5b1c2f6a7d8e" {
+		t.Errorf("Expected: %s, Got: %s", "0b5bb1ef-6b4c-4d9e-9c3a-5b1c2f6a7d8e", d.RequestID)
+	}
+	if d.UserAgent != "Atlassian HttpClient 1.1.0 / Bitbucket-7.21.0 (7021000) / Default" {
+		t.Errorf("Unexpected User-Agent: %s", d.UserAgent)
+	}
+	if d.ReceivedAt.IsZero() {
+		t.Errorf("Expected ReceivedAt to be set")
+	}
+
+	req.Header.Set("X-Request-Id", "changed")
+	if d.Header.Get("X-Request-Id") != "0b5bb1ef-6b4c-4d9e-9c3a-5b1c2f6a7d8e" {
+		t.Errorf("Expected the delivery to hold a copy of the request headers")
+	}
+}
+
+func TestDeliveryFromContext(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", NewPullRequestOpened())
+	req.Header.Set("X-Event-Key", "pr:opened")
+	req.Header.Set("X-Request-Id", "request-1")
+
+	var requestID string
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		if d, ok := DeliveryFromContext(ctx); ok {
+			requestID = d.RequestID
+		}
+		return nil
+	})
+
+	hook.ServeHTTP(httptest.NewRecorder(), req)
+
+	if requestID != "request-1" {
+		t.Errorf("Expected: %s, Got: %s", "request-1", requestID)
+	}
+
+	if _, ok := DeliveryFromContext(context.Background()); ok {
+		t.Errorf("Expected no delivery in an empty context")
+	}
+}
@@ -0,0 +1,342 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultWorkers is the number of workers of a Dispatcher unless WithWorkers is set
+	DefaultWorkers = 4
+	// DefaultQueueSize is the number of deliveries a Dispatcher queues unless WithQueueSize is set
+	DefaultQueueSize = 100
+)
+
+// DispatcherOption holds a dispatcher option
+type DispatcherOption func(*Dispatcher)
+
+// Dispatcher runs the handlers of a Webhook asynchronously. Requests are parsed and verified before responding, but
+// handlers are run on a pool of workers, so slow handlers do not cause Bitbucket to time out a delivery.
+type Dispatcher struct {
+	hook         *Webhook
+	workers      int
+	queueSize    int
+	errorHandler func(d *Delivery, err error)
+	ordered      bool
+	retryPolicy  RetryPolicy
+	deadLetters  DeadLetterSink
+	spool        *Spool
+
+	queues []chan dispatchJob
+	next   uint64
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewDispatcher creates a Dispatcher for the handlers registered on hook and starts its workers.
+//
+// Options:
+// - WithWorkers(8)
+// - WithQueueSize(1000)
+// - WithErrorHandler(func(d *Delivery, err error))
+// - WithOrdering()
+// - WithRetryPolicy(DefaultRetryPolicy)
+// - WithDeadLetterSink(sink)
+// - WithSpool(spool)
+func NewDispatcher(hook *Webhook, options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		hook:      hook,
+		workers:   DefaultWorkers,
+		queueSize: DefaultQueueSize,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	if d.workers < 1 {
+		d.workers = 1
+	}
+	if d.queueSize < 0 {
+		d.queueSize = 0
+	}
+
+	// Without ordering all workers share one queue. With ordering each worker has its own queue, so that the
+	// deliveries sent to a worker are handled in the order they were received.
+	shards := 1
+	if d.ordered {
+		shards = d.workers
+	}
+	for i := 0; i < shards; i++ {
+		d.queues = append(d.queues, make(chan dispatchJob, d.queueSize))
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work(d.queues[i%shards])
+	}
+
+	if d.spool != nil {
+		d.recoverSpool()
+	}
+
+	return d
+}
+
+// dispatchJob is a delivery waiting for a worker, with its sequence number in the spool
+type dispatchJob struct {
+	delivery *Delivery
+	seq      uint64
+}
+
+// WithWorkers sets the number of deliveries that are handled concurrently
+func WithWorkers(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.workers = n
+	}
+}
+
+// WithQueueSize sets the number of deliveries that can wait for a worker before requests are rejected. With
+// WithOrdering the size applies to the queue of each worker.
+func WithQueueSize(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.queueSize = n
+	}
+}
+
+// WithErrorHandler sets a function that is called when a handler returns an error. As the response has already been
+// sent, errors are otherwise only logged.
+func WithErrorHandler(fn func(d *Delivery, err error)) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.errorHandler = fn
+	}
+}
+
+// WithOrdering handles deliveries that share an OrderingKey one at a time, in the order they were received, while
+// deliveries for unrelated entities are still handled in parallel. Each delivery is sent to a worker chosen by a hash
+// of its key, so a slow delivery delays the other deliveries sent to the same worker.
+func WithOrdering() DispatcherOption {
+	return func(d *Dispatcher) {
+		d.ordered = true
+	}
+}
+
+// WithSpool appends each delivery to spool before responding, and acknowledges it once its handlers succeed or it is
+// stored as a dead letter. NewDispatcher queues the deliveries that were not acknowledged before the process stopped
+// and returns once they are all queued. Deliveries whose handlers are cancelled by Shutdown are not acknowledged.
+func WithSpool(spool *Spool) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.spool = spool
+	}
+}
+
+// ServeHTTP parses and verifies an incoming Bitbucket Webhook request and queues it for the workers of the
+// dispatcher. Rejected requests receive the status of their ParseError. A 202 Accepted is returned once the delivery
+// is queued, and a 503 Service Unavailable when the queue is full, the delivery cannot be written to the spool or the
+// dispatcher has been shut down.
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	delivery, err := d.hook.ParseDelivery(req)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+
+	if err := d.Enqueue(delivery); err != nil {
+		d.hook.logger.Warn("webhook delivery not queued", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "error", err)
+		d.hook.releaseDelivery(req.Context(), delivery)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Enqueue queues a delivery for the workers of the dispatcher without blocking, appending it to the spool first when
+// one is set. ErrQueueFull is returned when the queue is full, and ErrDispatcherClosed once Shutdown has been called.
+func (d *Dispatcher) Enqueue(delivery *Delivery) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	job := dispatchJob{delivery: delivery}
+	if d.spool != nil {
+		seq, err := d.spool.Append(delivery)
+		if err != nil {
+			return err
+		}
+		job.seq = seq
+	}
+
+	select {
+	case d.queue(delivery) <- job:
+		return nil
+	default:
+		d.ack(job)
+		return ErrQueueFull
+	}
+}
+
+// recoverSpool queues the deliveries that were not acknowledged in the spool, waiting for room in the queue
+func (d *Dispatcher) recoverSpool() {
+	if n := d.spool.Corrupt(); n > 0 {
+		d.hook.logger.Warn("skipped corrupt spool records", "count", n)
+	}
+
+	for _, entry := range d.spool.Pending() {
+		delivery, err := entry.delivery()
+		if err != nil {
+			d.hook.logger.Error("could not recover spooled delivery", "event_key", entry.EventKey, "request_id", entry.RequestID, "error", err)
+			d.ack(dispatchJob{seq: entry.Seq})
+			continue
+		}
+
+		d.hook.logger.Info("recovered spooled delivery", "event_key", entry.EventKey, "request_id", entry.RequestID)
+		d.queue(delivery) <- dispatchJob{delivery: delivery, seq: entry.Seq}
+	}
+}
+
+// ack acknowledges the spool entry of a job
+func (d *Dispatcher) ack(job dispatchJob) {
+	if d.spool == nil || job.seq == 0 {
+		return
+	}
+
+	if err := d.spool.Ack(job.seq); err != nil {
+		d.hook.logger.Error("could not acknowledge spooled delivery", "seq", job.seq, "error", err)
+	}
+}
+
+// Shutdown stops accepting deliveries and waits for the queued and in-flight deliveries to be handled. If ctx is done
+// first, the context passed to the running handlers is cancelled and the error of ctx is returned.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		return ctx.Err()
+	}
+}
+
+// queue returns the queue of the worker that handles a delivery
+func (d *Dispatcher) queue(delivery *Delivery) chan dispatchJob {
+	if len(d.queues) == 1 {
+		return d.queues[0]
+	}
+
+	key := OrderingKey(delivery.Event)
+	if key == "" {
+		return d.queues[atomic.AddUint64(&d.next, 1)%uint64(len(d.queues))]
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return d.queues[h.Sum64()%uint64(len(d.queues))]
+}
+
+func (d *Dispatcher) work(queue chan dispatchJob) {
+	defer d.wg.Done()
+
+	for job := range queue {
+		if d.handle(job.delivery) {
+			d.ack(job)
+		}
+	}
+}
+
+// handle calls the handlers for a delivery, retrying according to the retry policy, and reports the error of the
+// last attempt. It returns false when the delivery must stay in the spool to be handled again after a restart, because
+// its handlers were cancelled by Shutdown or it could not be stored as a dead letter.
+func (d *Dispatcher) handle(delivery *Delivery) bool {
+	ctx := contextWithDelivery(d.ctx, delivery)
+
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = d.attempt(ctx, delivery); err == nil {
+			return true
+		}
+
+		if attempts >= d.retryPolicy.MaxAttempts || d.ctx.Err() != nil {
+			break
+		}
+
+		backoff := d.retryPolicy.Backoff(attempts)
+		d.hook.logger.Warn("retrying webhook handler", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "attempt", attempts, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+		}
+
+		if d.ctx.Err() != nil {
+			break
+		}
+	}
+
+	if d.spool != nil && d.ctx.Err() != nil {
+		d.hook.logger.Warn("webhook handler cancelled, delivery left in spool", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "error", err)
+		return false
+	}
+
+	d.hook.logger.Error("webhook handler failed", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "attempts", attempts, "error", err)
+	if d.errorHandler != nil {
+		d.errorHandler(delivery, err)
+	}
+
+	if d.deadLetters != nil {
+		// The dispatcher context may already be cancelled by Shutdown, which must not prevent storing the letter
+		if putErr := d.deadLetters.Put(context.Background(), newDeadLetter(delivery, err, attempts)); putErr != nil {
+			d.hook.logger.Error("could not store dead letter", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "error", putErr)
+			return false
+		}
+	}
+
+	return true
+}
+
+// attempt runs the handlers for a delivery once. A panic in a handler is returned as an error wrapping
+// ErrHandlerPanic, so that it is retried and dead-lettered like any other failure instead of stopping the process.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\n%s", ErrHandlerPanic, r, debug.Stack())
+		}
+	}()
+
+	return d.hook.dispatch(ctx, delivery)
+}
@@ -0,0 +1,148 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newDispatcherRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"eventKey": "pr:opened"}`))
+	req.Header.Set("X-Event-Key", "pr:opened")
+	return req
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	d := NewDispatcher(hook, WithWorkers(1), WithQueueSize(1))
+
+	tc := []struct {
+		Name           string
+		Request        *http.Request
+		ExpectedStatus int
+	}{
+		{Name: "delivery handled by the worker", Request: newDispatcherRequest(), ExpectedStatus: http.StatusAccepted},
+		{Name: "delivery queued", Request: newDispatcherRequest(), ExpectedStatus: http.StatusAccepted},
+		{Name: "queue full", Request: newDispatcherRequest(), ExpectedStatus: http.StatusServiceUnavailable},
+		{Name: "invalid request", Request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")), ExpectedStatus: http.StatusBadRequest},
+	}
+
+	for i, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, tt.Request)
+
+		if rec.Code != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, rec.Code)
+		}
+
+		// Wait for the worker to take the first delivery off the queue
+		if i == 0 {
+			<-started
+		}
+	}
+
+	close(release)
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, newDispatcherRequest())
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status after shutdown: %d, Got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestDispatcherShutdownDrainsQueue(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled int
+		failed  int
+	)
+
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		time.Sleep(5 * time.Millisecond)
+		if _, ok := DeliveryFromContext(ctx); !ok {
+			t.Errorf("Expected the delivery in the handler context")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		if handled%2 == 0 {
+			return errors.New("build server unavailable")
+		}
+		return nil
+	})
+
+	d := NewDispatcher(hook, WithWorkers(2), WithQueueSize(10), WithErrorHandler(func(d *Delivery, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed++
+	}))
+
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, newDispatcherRequest())
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status: %d, Got: %d", http.StatusAccepted, rec.Code)
+		}
+	}
+
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if handled != 10 {
+		t.Errorf("Expected: %d, Got: %d", 10, handled)
+	}
+	if failed != 5 {
+		t.Errorf("Expected errors: %d, Got: %d", 5, failed)
+	}
+}
+
+func TestDispatcherShutdownTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+
+	d := NewDispatcher(hook, WithWorkers(1))
+	if err := d.Enqueue(&Delivery{EventKey: EventPullRequestOpened, Event: PullRequestOpenedPayload{}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := d.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected: %v, Got: %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the handler context to be cancelled")
+	}
+}
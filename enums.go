@@ -0,0 +1,316 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PullRequestState is the state of a pull request
+type PullRequestState string
+
+const (
+	// PullRequestOpen is used when a pull request is open
+	PullRequestOpen PullRequestState = "OPEN"
+	// PullRequestMerged is used when a pull request has been merged
+	PullRequestMerged PullRequestState = "MERGED"
+	// PullRequestDeclined is used when a pull request has been declined
+	PullRequestDeclined PullRequestState = "DECLINED"
+)
+
+var pullRequestStates = []string{"OPEN", "MERGED", "DECLINED"}
+
+// IsOpen returns true when the pull request is open
+func (s PullRequestState) IsOpen() bool { return s == PullRequestOpen }
+
+// IsMerged returns true when the pull request has been merged
+func (s PullRequestState) IsMerged() bool { return s == PullRequestMerged }
+
+// IsDeclined returns true when the pull request has been declined
+func (s PullRequestState) IsDeclined() bool { return s == PullRequestDeclined }
+
+// Valid returns true when s is a pull request state known to Bitbucket
+func (s PullRequestState) Valid() bool { return validEnum(string(s), pullRequestStates) }
+
+// MarshalJSON implements json.Marshaler
+func (s PullRequestState) MarshalJSON() ([]byte, error) {
+	return marshalEnum("pull request state", string(s), pullRequestStates)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown states.
+func (s *PullRequestState) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("pull request state", data, pullRequestStates)
+	*s = PullRequestState(v)
+	return err
+}
+
+// ParticipantRole is the role of a user in a pull request
+type ParticipantRole string
+
+const (
+	// RoleAuthor is the role of the user that opened the pull request
+	RoleAuthor ParticipantRole = "AUTHOR"
+	// RoleReviewer is the role of a user that was asked to review the pull request
+	RoleReviewer ParticipantRole = "REVIEWER"
+	// RoleParticipant is the role of a user that commented on or approved a pull request without being a reviewer
+	RoleParticipant ParticipantRole = "PARTICIPANT"
+)
+
+var participantRoles = []string{"AUTHOR", "REVIEWER", "PARTICIPANT"}
+
+// IsAuthor returns true for the author of a pull request
+func (r ParticipantRole) IsAuthor() bool { return r == RoleAuthor }
+
+// IsReviewer returns true for reviewers of a pull request
+func (r ParticipantRole) IsReviewer() bool { return r == RoleReviewer }
+
+// Valid returns true when r is a participant role known to Bitbucket
+func (r ParticipantRole) Valid() bool { return validEnum(string(r), participantRoles) }
+
+// MarshalJSON implements json.Marshaler
+func (r ParticipantRole) MarshalJSON() ([]byte, error) {
+	return marshalEnum("participant role", string(r), participantRoles)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown roles.
+func (r *ParticipantRole) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("participant role", data, participantRoles)
+	*r = ParticipantRole(v)
+	return err
+}
+
+// ParticipantStatus is the review status of a pull request participant
+type ParticipantStatus string
+
+const (
+	// StatusUnapproved is used when a participant has not approved the pull request
+	StatusUnapproved ParticipantStatus = "UNAPPROVED"
+	// StatusNeedsWork is used when a participant has asked for changes to the pull request
+	StatusNeedsWork ParticipantStatus = "NEEDS_WORK"
+	// StatusApproved is used when a participant has approved the pull request
+	StatusApproved ParticipantStatus = "APPROVED"
+)
+
+var participantStatuses = []string{"UNAPPROVED", "NEEDS_WORK", "APPROVED"}
+
+// IsApproved returns true when the participant has approved the pull request
+func (s ParticipantStatus) IsApproved() bool { return s == StatusApproved }
+
+// IsNeedsWork returns true when the participant has asked for changes to the pull request
+func (s ParticipantStatus) IsNeedsWork() bool { return s == StatusNeedsWork }
+
+// Valid returns true when s is a participant status known to Bitbucket
+func (s ParticipantStatus) Valid() bool { return validEnum(string(s), participantStatuses) }
+
+// MarshalJSON implements json.Marshaler
+func (s ParticipantStatus) MarshalJSON() ([]byte, error) {
+	return marshalEnum("participant status", string(s), participantStatuses)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown statuses.
+func (s *ParticipantStatus) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("participant status", data, participantStatuses)
+	*s = ParticipantStatus(v)
+	return err
+}
+
+// RefChangeType is the kind of change made to a ref by a push
+type RefChangeType string
+
+const (
+	// RefChangeAdd is used when a ref was created
+	RefChangeAdd RefChangeType = "ADD"
+	// RefChangeDelete is used when a ref was deleted
+	RefChangeDelete RefChangeType = "DELETE"
+	// RefChangeUpdate is used when a ref was moved to a new commit
+	RefChangeUpdate RefChangeType = "UPDATE"
+)
+
+var refChangeTypes = []string{"ADD", "DELETE", "UPDATE"}
+
+// IsAdd returns true when the ref was created
+func (t RefChangeType) IsAdd() bool { return t == RefChangeAdd }
+
+// IsDelete returns true when the ref was deleted
+func (t RefChangeType) IsDelete() bool { return t == RefChangeDelete }
+
+// IsUpdate returns true when the ref was moved to a new commit
+func (t RefChangeType) IsUpdate() bool { return t == RefChangeUpdate }
+
+// Valid returns true when t is a ref change type known to Bitbucket
+func (t RefChangeType) Valid() bool { return validEnum(string(t), refChangeTypes) }
+
+// MarshalJSON implements json.Marshaler
+func (t RefChangeType) MarshalJSON() ([]byte, error) {
+	return marshalEnum("ref change type", string(t), refChangeTypes)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown change types.
+func (t *RefChangeType) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("ref change type", data, refChangeTypes)
+	*t = RefChangeType(v)
+	return err
+}
+
+// RefType is the type of a git ref
+type RefType string
+
+const (
+	// RefTypeBranch is used for branches
+	RefTypeBranch RefType = "BRANCH"
+	// RefTypeTag is used for tags
+	RefTypeTag RefType = "TAG"
+)
+
+var refTypes = []string{"BRANCH", "TAG"}
+
+// IsBranch returns true when the ref is a branch
+func (t RefType) IsBranch() bool { return t == RefTypeBranch }
+
+// IsTag returns true when the ref is a tag
+func (t RefType) IsTag() bool { return t == RefTypeTag }
+
+// Valid returns true when t is a ref type known to Bitbucket
+func (t RefType) Valid() bool { return validEnum(string(t), refTypes) }
+
+// MarshalJSON implements json.Marshaler
+func (t RefType) MarshalJSON() ([]byte, error) {
+	return marshalEnum("ref type", string(t), refTypes)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown ref types.
+func (t *RefType) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("ref type", data, refTypes)
+	*t = RefType(v)
+	return err
+}
+
+// ProjectType is the type of a Bitbucket project
+type ProjectType string
+
+const (
+	// ProjectNormal is used for regular projects
+	ProjectNormal ProjectType = "NORMAL"
+	// ProjectPersonal is used for a user's personal project
+	ProjectPersonal ProjectType = "PERSONAL"
+)
+
+var projectTypes = []string{"NORMAL", "PERSONAL"}
+
+// IsPersonal returns true for a user's personal project
+func (t ProjectType) IsPersonal() bool { return t == ProjectPersonal }
+
+// Valid returns true when t is a project type known to Bitbucket
+func (t ProjectType) Valid() bool { return validEnum(string(t), projectTypes) }
+
+// MarshalJSON implements json.Marshaler
+func (t ProjectType) MarshalJSON() ([]byte, error) {
+	return marshalEnum("project type", string(t), projectTypes)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown project types.
+func (t *ProjectType) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("project type", data, projectTypes)
+	*t = ProjectType(v)
+	return err
+}
+
+// RepositoryState is the state of a Bitbucket repository
+type RepositoryState string
+
+const (
+	// RepositoryAvailable is used when a repository is ready to use
+	RepositoryAvailable RepositoryState = "AVAILABLE"
+	// RepositoryInitialising is used while a repository is being created
+	RepositoryInitialising RepositoryState = "INITIALISING"
+	// RepositoryInitialisationFailed is used when a repository could not be created
+	RepositoryInitialisationFailed RepositoryState = "INITIALISATION_FAILED"
+	// RepositoryOffline is used when a repository is temporarily unavailable
+	RepositoryOffline RepositoryState = "OFFLINE"
+)
+
+var repositoryStates = []string{"AVAILABLE", "INITIALISING", "INITIALISATION_FAILED", "OFFLINE"}
+
+// IsAvailable returns true when the repository is ready to use
+func (s RepositoryState) IsAvailable() bool { return s == RepositoryAvailable }
+
+// Valid returns true when s is a repository state known to Bitbucket
+func (s RepositoryState) Valid() bool { return validEnum(string(s), repositoryStates) }
+
+// MarshalJSON implements json.Marshaler
+func (s RepositoryState) MarshalJSON() ([]byte, error) {
+	return marshalEnum("repository state", string(s), repositoryStates)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown repository states.
+func (s *RepositoryState) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("repository state", data, repositoryStates)
+	*s = RepositoryState(v)
+	return err
+}
+
+// MirrorSyncType is the kind of synchronization performed by a smart mirror
+type MirrorSyncType string
+
+const (
+	// MirrorSyncIncremental is used when a mirror applied only the refs that changed
+	MirrorSyncIncremental MirrorSyncType = "incremental"
+	// MirrorSyncSnapshot is used when a mirror synchronized every ref of the repository
+	MirrorSyncSnapshot MirrorSyncType = "snapshot"
+)
+
+var mirrorSyncTypes = []string{"incremental", "snapshot"}
+
+// IsSnapshot returns true when every ref of the repository was synchronized
+func (t MirrorSyncType) IsSnapshot() bool { return t == MirrorSyncSnapshot }
+
+// Valid returns true when t is a mirror sync type known to Bitbucket
+func (t MirrorSyncType) Valid() bool { return validEnum(string(t), mirrorSyncTypes) }
+
+// MarshalJSON implements json.Marshaler
+func (t MirrorSyncType) MarshalJSON() ([]byte, error) {
+	return marshalEnum("mirror sync type", string(t), mirrorSyncTypes)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. An error is returned for unknown sync types.
+func (t *MirrorSyncType) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalEnum("mirror sync type", data, mirrorSyncTypes)
+	*t = MirrorSyncType(v)
+	return err
+}
+
+// validEnum reports whether v is one of the valid values. The empty string is always valid so that fields
+// Bitbucket omits keep their zero value.
+func validEnum(v string, valid []string) bool {
+	if v == "" {
+		return true
+	}
+
+	for _, s := range valid {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func marshalEnum(name, v string, valid []string) ([]byte, error) {
+	if !validEnum(v, valid) {
+		return nil, fmt.Errorf("invalid %s '%s'", name, v)
+	}
+
+	return json.Marshal(v)
+}
+
+func unmarshalEnum(name string, data []byte, valid []string) (string, error) {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	if !validEnum(v, valid) {
+		return "", fmt.Errorf("invalid %s '%s'", name, v)
+	}
+
+	return v, nil
+}
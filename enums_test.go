@@ -0,0 +1,77 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestEnumUnmarshal(t *testing.T) {
+	tc := []struct {
+		Name        string
+		JSON        string
+		Target      interface{}
+		ExpectedErr bool
+	}{
+		{Name: "valid pull request state", JSON: `"MERGED"`, Target: new(PullRequestState)},
+		{Name: "invalid pull request state", JSON: `"SUPERSEDED"`, Target: new(PullRequestState), ExpectedErr: true},
+		{Name: "valid participant role", JSON: `"REVIEWER"`, Target: new(ParticipantRole)},
+		{Name: "invalid participant role", JSON: `"OWNER"`, Target: new(ParticipantRole), ExpectedErr: true},
+		{Name: "valid participant status", JSON: `"NEEDS_WORK"`, Target: new(ParticipantStatus)},
+		{Name: "invalid participant status", JSON: `"needs_work"`, Target: new(ParticipantStatus), ExpectedErr: true},
+		{Name: "valid ref change type", JSON: `"DELETE"`, Target: new(RefChangeType)},
+		{Name: "invalid ref change type", JSON: `"RENAME"`, Target: new(RefChangeType), ExpectedErr: true},
+		{Name: "valid ref type", JSON: `"TAG"`, Target: new(RefType)},
+		{Name: "valid project type", JSON: `"PERSONAL"`, Target: new(ProjectType)},
+		{Name: "valid repository state", JSON: `"INITIALISATION_FAILED"`, Target: new(RepositoryState)},
+		{Name: "valid mirror sync type", JSON: `"snapshot"`, Target: new(MirrorSyncType)},
+		{Name: "empty value", JSON: `""`, Target: new(PullRequestState)},
+		{Name: "wrong JSON type", JSON: `1`, Target: new(RefType), ExpectedErr: true},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		err := json.Unmarshal([]byte(tt.JSON), tt.Target)
+		if tt.ExpectedErr && err == nil || !tt.ExpectedErr && err != nil {
+			t.Errorf("%s: Expected error: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
+
+func TestEnumMarshal(t *testing.T) {
+	b, err := json.Marshal(Changes{Type: RefChangeDelete})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var c Changes
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !c.Type.IsDelete() {
+		t.Errorf("Expected: %s, Got: %s", RefChangeDelete, c.Type)
+	}
+
+	if _, err := json.Marshal(PullRequest{State: "SUPERSEDED"}); err == nil {
+		t.Errorf("Expected an error when marshalling an invalid pull request state")
+	}
+}
+
+func TestEnumHelpers(t *testing.T) {
+	if !PullRequestMerged.IsMerged() || PullRequestOpen.IsMerged() {
+		t.Errorf("unexpected IsMerged result")
+	}
+	if !StatusApproved.IsApproved() || StatusNeedsWork.IsApproved() {
+		t.Errorf("unexpected IsApproved result")
+	}
+	if !RoleReviewer.IsReviewer() || RoleAuthor.IsReviewer() {
+		t.Errorf("unexpected IsReviewer result")
+	}
+	if !RefTypeTag.IsTag() || RefTypeBranch.IsTag() {
+		t.Errorf("unexpected IsTag result")
+	}
+	if PullRequestState("SUPERSEDED").Valid() {
+		t.Errorf("Expected SUPERSEDED to be an invalid pull request state")
+	}
+}
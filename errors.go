@@ -1,14 +1,71 @@
 package bitbucket
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrInvalidSignature is used when an HMAC signature cannot be valdiated
 	ErrInvalidSignature = errors.New("invalid secret or digest")
+	// ErrMissingSignature is used when a request is not signed but the signature policy requires it
+	ErrMissingSignature = errors.New("missing X-Hub-Signature header")
 	// ErrMissingSecret is used when a webhook's secret is not set
 	ErrMissingSecret = errors.New("expected secret to be set")
 	// ErrEventType is used when an incoming eventKey does not match any known keys
 	ErrEventType = errors.New("invalid event type")
 	// ErrReadingRequestBody is used when the request body cannot be read
 	ErrReadingRequestBody = errors.New("unable to read request body")
+	// ErrMethodNotAllowed is used when a request is not a POST request
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	// ErrContentType is used when a request has a Content-Type header that is not JSON
+	ErrContentType = errors.New("unsupported content type")
+	// ErrContentEncoding is used when a request body has an unsupported Content-Encoding or cannot be decompressed
+	ErrContentEncoding = errors.New("unsupported content encoding")
+	// ErrBodyTooLarge is used when a request body is larger than the maximum body size of the webhook
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrInvalidPayload is used when a request body is not a valid payload for its event key
+	ErrInvalidPayload = errors.New("invalid payload")
+	// ErrUnknownField is used when strict decoding is enabled and a payload contains fields that are not mapped
+	ErrUnknownField = errors.New("unknown fields")
+	// ErrHandlerPanic is used when a handler run by a Dispatcher or wrapped in the Recover middleware panics
+	ErrHandlerPanic = errors.New("handler panicked")
+	// ErrQueueFull is used when a Dispatcher cannot queue a delivery because its queue is full
+	ErrQueueFull = errors.New("dispatch queue is full")
+	// ErrDispatcherClosed is used when a delivery is queued after a Dispatcher has been shut down
+	ErrDispatcherClosed = errors.New("dispatcher is shut down")
+	// ErrDuplicateDelivery is used when replay protection is enabled and a delivery has already been accepted
+	ErrDuplicateDelivery = errors.New("duplicate delivery")
+	// ErrDeliveryTooOld is used when the date of an event is older than the maximum age of the webhook
+	ErrDeliveryTooOld = errors.New("delivery is too old")
+	// ErrSecretLookup is used when the SecretProvider of the webhook returns an error
+	ErrSecretLookup = errors.New("could not look up secret")
+	// ErrDeliveryStore is used when the DeliveryStore used for replay protection returns an error
+	ErrDeliveryStore = errors.New("delivery store failed")
 )
+
+// ParseError is returned by Parse and ParseDelivery when a request is rejected. It wraps one of the sentinel errors of
+// this package, so errors.Is can be used to find the cause, and carries the HTTP status to respond with.
+type ParseError struct {
+	// EventKey is the value of the X-Event-Key header of the request
+	EventKey EventKey
+
+	// Status is the HTTP status code to send back to Bitbucket
+	Status int
+
+	// Err is the cause of the error
+	Err error
+}
+
+// Error implements error
+func (e *ParseError) Error() string {
+	if e.EventKey == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("could not parse '%s' event: %s", e.EventKey, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,143 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+type failingDeliveryStore struct{}
+
+func (failingDeliveryStore) Record(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingDeliveryStore) Forget(ctx context.Context, key string) error {
+	return errors.New("database unavailable")
+}
+
+func TestParseErrors(t *testing.T) {
+	const (
+		secret = "secret"
+		body   = `{"eventKey": "pr:opened"}`
+	)
+
+	failingProvider := SecretProviderFunc(func(ctx context.Context, query SecretQuery) ([]Secret, error) {
+		return nil, errors.New("vault unavailable")
+	})
+
+	tc := []struct {
+		Name           string
+		Hook           *Webhook
+		EventKey       string
+		Body           io.Reader
+		Signature      string
+		ExpectedErr    error
+		ExpectedStatus int
+	}{
+		{Name: "missing event key", Hook: New(), Body: strings.NewReader(body), ExpectedErr: ErrEventType, ExpectedStatus: http.StatusBadRequest},
+		{Name: "unknown event key", Hook: New(), EventKey: "pr:fake", Body: strings.NewReader(body), ExpectedErr: ErrEventType, ExpectedStatus: http.StatusBadRequest},
+		{Name: "unreadable body", Hook: New(), EventKey: "pr:opened", Body: failingReader{}, ExpectedErr: ErrReadingRequestBody, ExpectedStatus: http.StatusBadRequest},
+		{Name: "empty body", Hook: New(), EventKey: "pr:opened", Body: strings.NewReader(""), ExpectedErr: ErrReadingRequestBody, ExpectedStatus: http.StatusBadRequest},
+		{Name: "invalid JSON", Hook: New(), EventKey: "pr:opened", Body: strings.NewReader(`{"eventKey":`), ExpectedErr: ErrInvalidPayload, ExpectedStatus: http.StatusBadRequest},
+		{Name: "unknown field", Hook: New(WithStrictDecoding()), EventKey: "pr:opened", Body: strings.NewReader(`{"eventKey": "pr:opened", "newField": 1}`), ExpectedErr: ErrUnknownField, ExpectedStatus: http.StatusBadRequest},
+		{Name: "missing signature", Hook: New(WithSecret(secret)), EventKey: "pr:opened", Body: strings.NewReader(body), ExpectedErr: ErrMissingSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "invalid signature", Hook: New(WithSecret(secret)), EventKey: "pr:opened", Body: strings.NewReader(body), Signature: signature("wrong", body), ExpectedErr: ErrInvalidSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "invalid hash prefix", Hook: New(WithSecret(secret)), EventKey: "pr:opened", Body: strings.NewReader(body), Signature: "md4=abcd", ExpectedErr: ErrInvalidSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "invalid hex signature", Hook: New(WithSecret(secret)), EventKey: "pr:opened", Body: strings.NewReader(body), Signature: "sha256=xyz", ExpectedErr: ErrInvalidSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "signed request without a secret", Hook: New(WithSignaturePolicy(SignatureRequired)), EventKey: "pr:opened", Body: strings.NewReader(body), Signature: signature(secret, body), ExpectedErr: ErrMissingSecret, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "secret provider error", Hook: New(WithSecretProvider(failingProvider)), EventKey: "pr:opened", Body: strings.NewReader(body), Signature: signature(secret, body), ExpectedErr: ErrSecretLookup, ExpectedStatus: http.StatusInternalServerError},
+		{Name: "delivery store error", Hook: New(WithReplayProtection(failingDeliveryStore{}, time.Hour)), EventKey: "pr:opened", Body: strings.NewReader(body), ExpectedErr: ErrDeliveryStore, ExpectedStatus: http.StatusInternalServerError},
+		{Name: "event too old", Hook: New(WithMaxAge(time.Hour)), EventKey: "pr:opened", Body: strings.NewReader(`{"eventKey": "pr:opened", "date": "2022-09-14T05:40:02+0000"}`), ExpectedErr: ErrDeliveryTooOld, ExpectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", tt.Body)
+		if tt.EventKey != "" {
+			req.Header.Set("X-Event-Key", tt.EventKey)
+		}
+		if tt.Signature != "" {
+			req.Header.Set("X-Hub-Signature", tt.Signature)
+		}
+
+		_, err := tt.Hook.Parse(req)
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: Expected: *ParseError, Got: %T", tt.Name, err)
+			continue
+		}
+		if parseErr.EventKey != EventKey(tt.EventKey) {
+			t.Errorf("%s: Expected event key: %s, Got: %s", tt.Name, tt.EventKey, parseErr.EventKey)
+		}
+		if parseErr.Status != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, parseErr.Status)
+		}
+		if tt.ExpectedErr != nil && !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
+
+func TestDuplicateDeliveryStatus(t *testing.T) {
+	const body = `{"eventKey": "pr:opened"}`
+
+	hook := New(WithReplayProtection(NewMemoryDeliveryStore(10), time.Hour))
+
+	for i, expected := range []int{http.StatusOK, http.StatusConflict} {
+		fmt.Println("Test: delivery", i+1)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		rec := httptest.NewRecorder()
+
+		hook.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("Expected: %d, Got: %d", expected, rec.Code)
+		}
+	}
+}
+
+func TestVerifySignatureErrors(t *testing.T) {
+	const (
+		secret  = "secret"
+		payload = `{"eventKey": "pr:opened"}`
+	)
+
+	tc := []struct {
+		Name        string
+		Payload     string
+		Signature   string
+		Secret      string
+		ExpectedErr error
+	}{
+		{Name: "missing signature", Payload: payload, Secret: secret, ExpectedErr: ErrMissingSignature},
+		{Name: "missing secret", Payload: payload, Signature: signature(secret, payload), ExpectedErr: ErrMissingSecret},
+		{Name: "empty payload", Signature: signature(secret, payload), Secret: secret, ExpectedErr: ErrReadingRequestBody},
+		{Name: "signature mismatch", Payload: payload, Signature: signature("wrong", payload), Secret: secret, ExpectedErr: ErrInvalidSignature},
+		{Name: "valid signature", Payload: payload, Signature: signature(secret, payload), Secret: secret},
+	}
+
+	hook := New()
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		err := hook.VerifySignature([]byte(tt.Payload), tt.Signature, tt.Secret)
+		if !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package bitbucket
+
+import "time"
+
+// Event is implemented by every payload type returned by Parse. It allows generic code, such as logging or metrics,
+// to be written once for all events. Use a type assertion to ActorEvent, RepositoryEvent or ProjectEvent to get
+// the actor, repository or project of events that include them.
+type Event interface {
+	// Key returns the event key of the event
+	Key() EventKey
+	// Date returns the date the event occurred
+	Date() time.Time
+}
+
+// ActorEvent is implemented by events that were triggered by a user
+type ActorEvent interface {
+	Event
+	// EventActor returns the user that triggered the event
+	EventActor() Actor
+}
+
+// RepositoryEvent is implemented by events that occurred in a repository. For pull request events this is the
+// repository the pull request targets, and for "repo:modified" events the repository after it was modified.
+type RepositoryEvent interface {
+	Event
+	// EventRepository returns the repository the event occurred in
+	EventRepository() Repository
+}
+
+// ProjectEvent is implemented by events that occurred in a project
+type ProjectEvent interface {
+	Event
+	// EventProject returns the project the event occurred in
+	EventProject() Project
+}
+
+// Event keys sent by Bitbucket Server in the X-Event-Key header
+const (
+	// EventDiagnosticsPing is sent when a webhook is tested from the Bitbucket UI
+	EventDiagnosticsPing EventKey = "diagnostics:ping"
+
+	// EventPullRequestOpened is sent when a pull request is opened
+	EventPullRequestOpened EventKey = "pr:opened"
+	// EventPullRequestModified is sent when the title, description or target branch of a pull request changes
+	EventPullRequestModified EventKey = "pr:modified"
+	// EventPullRequestFromRefUpdated is sent when new commits are pushed to the source branch of a pull request
+	EventPullRequestFromRefUpdated EventKey = "pr:from_ref_updated"
+	// EventPullRequestMerged is sent when a pull request is merged
+	EventPullRequestMerged EventKey = "pr:merged"
+	// EventPullRequestDeclined is sent when a pull request is declined
+	EventPullRequestDeclined EventKey = "pr:declined"
+	// EventPullRequestDeleted is sent when a pull request is deleted
+	EventPullRequestDeleted EventKey = "pr:deleted"
+	// EventPullRequestCommentAdded is sent when a comment is added to a pull request
+	EventPullRequestCommentAdded EventKey = "pr:comment:added"
+	// EventPullRequestCommentEdited is sent when a pull request comment is edited
+	EventPullRequestCommentEdited EventKey = "pr:comment:edited"
+	// EventPullRequestCommentDeleted is sent when a pull request comment is deleted
+	EventPullRequestCommentDeleted EventKey = "pr:comment:deleted"
+	// EventPullRequestReviewerUpdated is sent when the reviewers of a pull request change
+	EventPullRequestReviewerUpdated EventKey = "pr:reviewer:updated"
+	// EventPullRequestReviewerApproved is sent when a reviewer approves a pull request
+	EventPullRequestReviewerApproved EventKey = "pr:reviewer:approved"
+	// EventPullRequestReviewerUnapproved is sent when a reviewer removes their approval
+	EventPullRequestReviewerUnapproved EventKey = "pr:reviewer:unapproved"
+	// EventPullRequestReviewerNeedsWork is sent when a reviewer marks a pull request as needing work
+	EventPullRequestReviewerNeedsWork EventKey = "pr:reviewer:needs_work"
+
+	// EventRepoRefsChanged is sent when a push changes one or more refs
+	EventRepoRefsChanged EventKey = "repo:refs_changed"
+	// EventRepoModified is sent when a repository is renamed or moved
+	EventRepoModified EventKey = "repo:modified"
+	// EventRepoForked is sent when a repository is forked
+	EventRepoForked EventKey = "repo:forked"
+	// EventRepoCommentAdded is sent when a comment is added to a commit
+	EventRepoCommentAdded EventKey = "repo:comment:added"
+	// EventRepoCommentEdited is sent when a commit comment is edited
+	EventRepoCommentEdited EventKey = "repo:comment:edited"
+	// EventRepoCommentDeleted is sent when a commit comment is deleted
+	EventRepoCommentDeleted EventKey = "repo:comment:deleted"
+
+	// EventRepoSecretDetected is sent when secret scanning finds a secret in a pushed commit
+	EventRepoSecretDetected EventKey = "repo:secret_detected"
+
+	// EventProjectModified is sent when a project is renamed or its key or description changes
+	EventProjectModified EventKey = "project:modified"
+
+	// EventMirrorRepoSynchronized is sent by a smart mirror after synchronizing a repository
+	EventMirrorRepoSynchronized EventKey = "mirror:repo_synchronized"
+)
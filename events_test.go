@@ -0,0 +1,101 @@
+package bitbucket
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventInterfaces(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Event      Event
+		Actor      bool
+		Repository bool
+		Project    bool
+	}{
+		{Name: "DiagnosticPingEvent", Event: DiagnosticPingEvent{}},
+		{Name: "PullRequestOpenedPayload", Event: PullRequestOpenedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestModifiedPayload", Event: PullRequestModifiedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestMergedPayload", Event: PullRequestMergedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestDeclinedPayload", Event: PullRequestDeclinedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestDeletedPayload", Event: PullRequestDeletedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestReviewerPayload", Event: PullRequestReviewerPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestReviewerUpdatedPayload", Event: PullRequestReviewerUpdatedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestCommentAddedPayload", Event: PullRequestCommentAddedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestCommentEditedPayload", Event: PullRequestCommentEditedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "PullRequestCommentDeletedPayload", Event: PullRequestCommentDeletedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "FromRefUpdatedPayload", Event: FromRefUpdatedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoRefsChangedPayload", Event: RepoRefsChangedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoModifiedPayload", Event: RepoModifiedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoForkPayload", Event: RepoForkPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoCommentAddedPayload", Event: RepoCommentAddedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoCommentEditedPayload", Event: RepoCommentEditedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoCommentDeletedPayload", Event: RepoCommentDeletedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "RepoSecretDetectedPayload", Event: RepoSecretDetectedPayload{}, Actor: true, Repository: true, Project: true},
+		{Name: "ProjectModifiedPayload", Event: ProjectModifiedPayload{}, Actor: true, Project: true},
+		{Name: "MirrorRepoSynchronizedPayload", Event: MirrorRepoSynchronizedPayload{}, Repository: true, Project: true},
+	}
+
+	for _, tt := range tc {
+		if _, ok := tt.Event.(ActorEvent); ok != tt.Actor {
+			t.Errorf("%s: Expected ActorEvent: %v, Got: %v", tt.Name, tt.Actor, ok)
+		}
+		if _, ok := tt.Event.(RepositoryEvent); ok != tt.Repository {
+			t.Errorf("%s: Expected RepositoryEvent: %v, Got: %v", tt.Name, tt.Repository, ok)
+		}
+		if _, ok := tt.Event.(ProjectEvent); ok != tt.Project {
+			t.Errorf("%s: Expected ProjectEvent: %v, Got: %v", tt.Name, tt.Project, ok)
+		}
+	}
+}
+
+func TestEventAccessors(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/pr_comment_added.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("X-Event-Key", "pr:comment:added")
+
+	event, err := New().Parse(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fmt.Println("Test: event accessors")
+	if event.Key() != EventPullRequestCommentAdded {
+		t.Errorf("Expected key: %s, Got: %s", EventPullRequestCommentAdded, event.Key())
+	}
+	expectedDate := time.Date(2022, time.September, 14, 5, 40, 2, 0, time.UTC)
+	if !event.Date().Equal(expectedDate) {
+		t.Errorf("Expected date: %s, Got: %s", expectedDate, event.Date())
+	}
+	if actor := event.(ActorEvent).EventActor(); actor.Name != "reviewer" {
+		t.Errorf("Expected actor: %s, Got: %s", "reviewer", actor.Name)
+	}
+	if repo := event.(RepositoryEvent).EventRepository(); repo.Slug != "payments" {
+		t.Errorf("Expected repository: %s, Got: %s", "payments", repo.Slug)
+	}
+	if project := event.(ProjectEvent).EventProject(); project.Key != "SEC" {
+		t.Errorf("Expected project: %s, Got: %s", "SEC", project.Key)
+	}
+}
+
+func TestRepoModifiedRepository(t *testing.T) {
+	event := RepoModifiedPayload{
+		OldVersion: RepoVersion{ID: 42, Slug: "payments"},
+		NewVersion: RepoVersion{ID: 42, Slug: "billing", Name: "Billing", Project: Project{Key: "SEC"}},
+	}
+
+	fmt.Println("Test: repository is built from the new version")
+	repo := event.EventRepository()
+	if repo.ID != 42 || repo.Slug != "billing" || repo.Name != "Billing" || repo.Project.Key != "SEC" {
+		t.Errorf("Expected repository: %+v, Got: %+v", event.NewVersion, repo)
+	}
+}
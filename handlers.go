@@ -0,0 +1,251 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// EventHandler handles a parsed Bitbucket Webhook event. The event is one of the payload types returned by Parse.
+type EventHandler func(ctx context.Context, event Event) error
+
+// On registers a handler for an event key. Multiple handlers can be registered for the same event key and are
+// called in the order they were registered. Handlers should be registered before the Webhook starts serving requests.
+func (hook *Webhook) On(key EventKey, handler EventHandler) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.handlers[key] = append(hook.handlers[key], handler)
+}
+
+// ServeHTTP parses an incoming Bitbucket Webhook request and calls the handlers registered for its event key. The
+// context passed to handlers carries the Delivery of the request, see DeliveryFromContext.
+//
+// Requests that are rejected receive the status of the ParseError returned by ParseDelivery, such as 400 Bad Request
+// for invalid payloads and 401 Unauthorized for invalid signatures. When a handler returns an error a 500 Internal
+// Server Error is sent back to Bitbucket and the delivery is released from replay protection, so that it is accepted
+// when Bitbucket retries it. Otherwise a 200 OK is returned, including for events that do not have a registered
+// handler.
+func (hook *Webhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	delivery, err := hook.ParseDelivery(req)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+
+	ctx := contextWithDelivery(req.Context(), delivery)
+	if err := hook.dispatch(ctx, delivery); err != nil {
+		hook.logger.Error("webhook handler failed", "event_key", delivery.EventKey, "request_id", delivery.RequestID, "error", err)
+		hook.releaseDelivery(req.Context(), delivery)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeParseError responds with the status of a ParseError. The message of server errors is not sent to the client.
+func writeParseError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		status = parseErr.Status
+	}
+
+	if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", http.MethodPost)
+	}
+
+	if status >= http.StatusInternalServerError {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+// dispatch calls each handler registered for the event key of a delivery, wrapped in its middleware, stopping at the
+// first handler that returns an error. Handlers that already completed for the delivery are skipped when idempotency
+// is enabled.
+func (hook *Webhook) dispatch(ctx context.Context, d *Delivery) error {
+	handlers := hook.handlersFor(d.EventKey)
+
+	if hook.idempotencyStore != nil {
+		return hook.dispatchOnce(ctx, d, handlers)
+	}
+
+	return hook.callHandlers(ctx, d.EventKey, d.Event, handlers)
+}
+
+// callHandlers calls each handler in turn, stopping at the first handler that returns an error
+func (hook *Webhook) callHandlers(ctx context.Context, key EventKey, event Event, handlers []EventHandler) error {
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			return fmt.Errorf("handler for '%s' failed: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// OnDiagnosticsPing registers a handler for "diagnostics:ping" events
+func (hook *Webhook) OnDiagnosticsPing(fn func(context.Context, DiagnosticPingEvent) error) {
+	hook.On(EventDiagnosticsPing, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(DiagnosticPingEvent))
+	})
+}
+
+// OnPullRequestOpened registers a handler for "pr:opened" events
+func (hook *Webhook) OnPullRequestOpened(fn func(context.Context, PullRequestOpenedPayload) error) {
+	hook.On(EventPullRequestOpened, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestOpenedPayload))
+	})
+}
+
+// OnPullRequestModified registers a handler for "pr:modified" events
+func (hook *Webhook) OnPullRequestModified(fn func(context.Context, PullRequestModifiedPayload) error) {
+	hook.On(EventPullRequestModified, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestModifiedPayload))
+	})
+}
+
+// OnPullRequestFromRefUpdated registers a handler for "pr:from_ref_updated" events
+func (hook *Webhook) OnPullRequestFromRefUpdated(fn func(context.Context, FromRefUpdatedPayload) error) {
+	hook.On(EventPullRequestFromRefUpdated, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(FromRefUpdatedPayload))
+	})
+}
+
+// OnPullRequestMerged registers a handler for "pr:merged" events
+func (hook *Webhook) OnPullRequestMerged(fn func(context.Context, PullRequestMergedPayload) error) {
+	hook.On(EventPullRequestMerged, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestMergedPayload))
+	})
+}
+
+// OnPullRequestDeclined registers a handler for "pr:declined" events
+func (hook *Webhook) OnPullRequestDeclined(fn func(context.Context, PullRequestDeclinedPayload) error) {
+	hook.On(EventPullRequestDeclined, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestDeclinedPayload))
+	})
+}
+
+// OnPullRequestDeleted registers a handler for "pr:deleted" events
+func (hook *Webhook) OnPullRequestDeleted(fn func(context.Context, PullRequestDeletedPayload) error) {
+	hook.On(EventPullRequestDeleted, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestDeletedPayload))
+	})
+}
+
+// OnPullRequestCommentAdded registers a handler for "pr:comment:added" events
+func (hook *Webhook) OnPullRequestCommentAdded(fn func(context.Context, PullRequestCommentAddedPayload) error) {
+	hook.On(EventPullRequestCommentAdded, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestCommentAddedPayload))
+	})
+}
+
+// OnPullRequestCommentEdited registers a handler for "pr:comment:edited" events
+func (hook *Webhook) OnPullRequestCommentEdited(fn func(context.Context, PullRequestCommentEditedPayload) error) {
+	hook.On(EventPullRequestCommentEdited, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestCommentEditedPayload))
+	})
+}
+
+// OnPullRequestCommentDeleted registers a handler for "pr:comment:deleted" events
+func (hook *Webhook) OnPullRequestCommentDeleted(fn func(context.Context, PullRequestCommentDeletedPayload) error) {
+	hook.On(EventPullRequestCommentDeleted, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestCommentDeletedPayload))
+	})
+}
+
+// OnPullRequestReviewerUpdated registers a handler for "pr:reviewer:updated" events
+func (hook *Webhook) OnPullRequestReviewerUpdated(fn func(context.Context, PullRequestReviewerUpdatedPayload) error) {
+	hook.On(EventPullRequestReviewerUpdated, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestReviewerUpdatedPayload))
+	})
+}
+
+// OnPullRequestReviewerApproved registers a handler for "pr:reviewer:approved" events
+func (hook *Webhook) OnPullRequestReviewerApproved(fn func(context.Context, PullRequestReviewerPayload) error) {
+	hook.On(EventPullRequestReviewerApproved, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestReviewerPayload))
+	})
+}
+
+// OnPullRequestReviewerUnapproved registers a handler for "pr:reviewer:unapproved" events
+func (hook *Webhook) OnPullRequestReviewerUnapproved(fn func(context.Context, PullRequestReviewerPayload) error) {
+	hook.On(EventPullRequestReviewerUnapproved, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestReviewerPayload))
+	})
+}
+
+// OnPullRequestReviewerNeedsWork registers a handler for "pr:reviewer:needs_work" events
+func (hook *Webhook) OnPullRequestReviewerNeedsWork(fn func(context.Context, PullRequestReviewerPayload) error) {
+	hook.On(EventPullRequestReviewerNeedsWork, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(PullRequestReviewerPayload))
+	})
+}
+
+// OnRepoRefsChanged registers a handler for "repo:refs_changed" events
+func (hook *Webhook) OnRepoRefsChanged(fn func(context.Context, RepoRefsChangedPayload) error) {
+	hook.On(EventRepoRefsChanged, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoRefsChangedPayload))
+	})
+}
+
+// OnRepoModified registers a handler for "repo:modified" events
+func (hook *Webhook) OnRepoModified(fn func(context.Context, RepoModifiedPayload) error) {
+	hook.On(EventRepoModified, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoModifiedPayload))
+	})
+}
+
+// OnRepoForked registers a handler for "repo:forked" events
+func (hook *Webhook) OnRepoForked(fn func(context.Context, RepoForkPayload) error) {
+	hook.On(EventRepoForked, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoForkPayload))
+	})
+}
+
+// OnRepoCommentAdded registers a handler for "repo:comment:added" events
+func (hook *Webhook) OnRepoCommentAdded(fn func(context.Context, RepoCommentAddedPayload) error) {
+	hook.On(EventRepoCommentAdded, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoCommentAddedPayload))
+	})
+}
+
+// OnRepoCommentEdited registers a handler for "repo:comment:edited" events
+func (hook *Webhook) OnRepoCommentEdited(fn func(context.Context, RepoCommentEditedPayload) error) {
+	hook.On(EventRepoCommentEdited, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoCommentEditedPayload))
+	})
+}
+
+// OnRepoCommentDeleted registers a handler for "repo:comment:deleted" events
+func (hook *Webhook) OnRepoCommentDeleted(fn func(context.Context, RepoCommentDeletedPayload) error) {
+	hook.On(EventRepoCommentDeleted, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoCommentDeletedPayload))
+	})
+}
+
+// OnRepoSecretDetected registers a handler for "repo:secret_detected" events
+func (hook *Webhook) OnRepoSecretDetected(fn func(context.Context, RepoSecretDetectedPayload) error) {
+	hook.On(EventRepoSecretDetected, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(RepoSecretDetectedPayload))
+	})
+}
+
+// OnProjectModified registers a handler for "project:modified" events
+func (hook *Webhook) OnProjectModified(fn func(context.Context, ProjectModifiedPayload) error) {
+	hook.On(EventProjectModified, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(ProjectModifiedPayload))
+	})
+}
+
+// OnMirrorRepoSynchronized registers a handler for "mirror:repo_synchronized" events
+func (hook *Webhook) OnMirrorRepoSynchronized(fn func(context.Context, MirrorRepoSynchronizedPayload) error) {
+	hook.On(EventMirrorRepoSynchronized, func(ctx context.Context, event Event) error {
+		return fn(ctx, event.(MirrorRepoSynchronizedPayload))
+	})
+}
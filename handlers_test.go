@@ -0,0 +1,97 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	tc := []struct {
+		Name           string
+		EventKey       string
+		HandlerErr     error
+		ExpectedStatus int
+		ExpectedCalls  int
+	}{
+		{
+			Name:           "handled pr:opened",
+			EventKey:       "pr:opened",
+			ExpectedStatus: http.StatusOK,
+			ExpectedCalls:  1,
+		},
+		{
+			Name:           "handler error",
+			EventKey:       "pr:opened",
+			HandlerErr:     errors.New("build server unavailable"),
+			ExpectedStatus: http.StatusInternalServerError,
+			ExpectedCalls:  1,
+		},
+		{
+			Name:           "no handler registered",
+			EventKey:       "pr:declined",
+			ExpectedStatus: http.StatusOK,
+			ExpectedCalls:  0,
+		},
+		{
+			Name:           "invalid event key",
+			EventKey:       "pr:fake",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCalls:  0,
+		},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", NewPullRequestOpened())
+		req.Header.Set("X-Event-Key", tt.EventKey)
+		rec := httptest.NewRecorder()
+
+		calls := 0
+		hook := New()
+		hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+			calls++
+			return tt.HandlerErr
+		})
+
+		hook.ServeHTTP(rec, req)
+
+		if rec.Code != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, rec.Code)
+		}
+
+		if calls != tt.ExpectedCalls {
+			t.Errorf("%s: Expected calls: %d, Got: %d", tt.Name, tt.ExpectedCalls, calls)
+		}
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	var order []int
+
+	hook := New()
+	hook.OnPullRequestReviewerApproved(func(ctx context.Context, pl PullRequestReviewerPayload) error {
+		order = append(order, 1)
+		return nil
+	})
+	hook.OnPullRequestReviewerApproved(func(ctx context.Context, pl PullRequestReviewerPayload) error {
+		order = append(order, 2)
+		return errors.New("stop")
+	})
+	hook.OnPullRequestReviewerApproved(func(ctx context.Context, pl PullRequestReviewerPayload) error {
+		order = append(order, 3)
+		return nil
+	})
+
+	err := hook.dispatch(context.Background(), &Delivery{EventKey: EventPullRequestReviewerApproved, Event: PullRequestReviewerPayload{}})
+	if err == nil {
+		t.Errorf("Expected an error from the second handler")
+	}
+
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Errorf("Expected handlers [1 2] to be called, Got: %v", order)
+	}
+}
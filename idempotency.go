@@ -0,0 +1,203 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyStore records which handlers have completed for a delivery, so that a delivery retried by Bitbucket
+// does not run a handler a second time
+type IdempotencyStore interface {
+	// Completed reports whether key has been marked as completed
+	Completed(ctx context.Context, key string) (bool, error)
+
+	// Complete marks key as completed
+	Complete(ctx context.Context, key string) error
+}
+
+// WithIdempotency records each handler that completes for a delivery in store. When the same delivery is received
+// again, handlers that already succeeded are skipped and only the handlers that failed or did not run are called.
+// Deliveries are identified by IdempotencyKey, and handlers by the order they were registered in, so handlers must be
+// registered in the same order every time the program starts.
+func WithIdempotency(store IdempotencyStore) Option {
+	return func(w *Webhook) {
+		w.idempotencyStore = store
+	}
+}
+
+// IdempotencyKey returns a stable key for a delivery. The X-Request-Id header is used when it is set. Otherwise the
+// key is built from the event key, the ID of the pull request or comment and its version, or from a hash of the
+// payload for other events. An empty key is returned for "diagnostics:ping" events, which are not tracked.
+func IdempotencyKey(d *Delivery) string {
+	if d.EventKey == EventDiagnosticsPing {
+		return ""
+	}
+
+	if d.RequestID != "" {
+		return "request:" + d.RequestID
+	}
+
+	key := string(d.EventKey)
+	switch event := d.Event.(type) {
+	case PullRequestOpenedPayload:
+		return key + pullRequestKey(event.PullRequest)
+	case PullRequestModifiedPayload:
+		return key + pullRequestKey(event.PullRequest)
+	case PullRequestMergedPayload:
+		return key + pullRequestKey(event.PullRequest)
+	case PullRequestDeclinedPayload:
+		return key + pullRequestKey(event.PullRequest)
+	case PullRequestDeletedPayload:
+		return key + pullRequestKey(event.PullRequest)
+	case FromRefUpdatedPayload:
+		return key + fmt.Sprintf("/%d/%d@%s", event.PullRequest.ToRef.Repository.ID, event.PullRequest.ID, event.PullRequest.FromRef.LatestCommit)
+	case PullRequestCommentAddedPayload:
+		return key + commentKey(event.PullRequest, event.Comment)
+	case PullRequestCommentEditedPayload:
+		return key + commentKey(event.PullRequest, event.Comment)
+	case PullRequestCommentDeletedPayload:
+		return key + commentKey(event.PullRequest, event.Comment)
+	}
+
+	sum := sha256.Sum256(d.Raw)
+	return key + "/sha256:" + hex.EncodeToString(sum[:])
+}
+
+func pullRequestKey(pr PullRequest) string {
+	return fmt.Sprintf("/%d/%d@%d", pr.ToRef.Repository.ID, pr.ID, pr.Version)
+}
+
+func commentKey(pr PullRequest, comment Comment) string {
+	return fmt.Sprintf("/%d/%d/comment/%d@%d", pr.ToRef.Repository.ID, pr.ID, comment.ID, comment.Version)
+}
+
+// dispatchOnce calls the handlers for a delivery like dispatch, skipping the handlers that already completed for its
+// idempotency key. Concurrent deliveries with the same key are processed one at a time.
+func (hook *Webhook) dispatchOnce(ctx context.Context, d *Delivery, handlers []EventHandler) error {
+	key := IdempotencyKey(d)
+	if key == "" {
+		return hook.callHandlers(ctx, d.EventKey, d.Event, handlers)
+	}
+
+	unlock := hook.deliveryLocks.lock(key)
+	defer unlock()
+
+	for i, handler := range handlers {
+		handlerKey := fmt.Sprintf("%s#%d", key, i)
+
+		completed, err := hook.idempotencyStore.Completed(ctx, handlerKey)
+		if err != nil {
+			return fmt.Errorf("could not check handler completion: %w", err)
+		}
+		if completed {
+			hook.logger.Debug("handler already completed", "event_key", d.EventKey, "request_id", d.RequestID, "idempotency_key", handlerKey)
+			continue
+		}
+
+		if err := handler(ctx, d.Event); err != nil {
+			return fmt.Errorf("handler for '%s' failed: %w", d.EventKey, err)
+		}
+
+		if err := hook.idempotencyStore.Complete(ctx, handlerKey); err != nil {
+			return fmt.Errorf("could not record handler completion: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// keyLocks is a set of mutexes that are created on demand for a key and removed once they are no longer used
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the mutex for key and returns a function that unlocks it
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.mu.Lock()
+
+	return func() {
+		kl.mu.Unlock()
+
+		l.mu.Lock()
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Keys are forgotten once ttl has passed, or kept forever
+// when ttl is zero. It is safe for concurrent use.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	completed map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryIdempotencyStore creates a MemoryIdempotencyStore that remembers completed keys for ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:       ttl,
+		completed: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// Completed implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Completed(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	completedAt, ok := s.completed[key]
+	if !ok {
+		return false, nil
+	}
+
+	return s.ttl == 0 || s.now().Sub(completedAt) < s.ttl, nil
+}
+
+// Complete implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.completed[key] = now
+
+	// Remove expired keys at most once per ttl
+	if s.ttl > 0 && now.Sub(s.lastSweep) >= s.ttl {
+		for k, completedAt := range s.completed {
+			if now.Sub(completedAt) >= s.ttl {
+				delete(s.completed, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	pr := PullRequest{ID: 7, Version: 3}
+	pr.ToRef.Repository.ID = 42
+
+	tc := []struct {
+		Name     string
+		Delivery *Delivery
+		Expected string
+	}{
+		{Name: "request ID", Delivery: &Delivery{EventKey: EventPullRequestOpened, RequestID: "abc", Event: PullRequestOpenedPayload{PullRequest: pr}}, Expected: "request:abc"},
+		{Name: "pull request version", Delivery: &Delivery{EventKey: EventPullRequestModified, Event: PullRequestModifiedPayload{PullRequest: pr}}, Expected: "pr:modified/42/7@3"},
+		{Name: "comment version", Delivery: &Delivery{EventKey: EventPullRequestCommentEdited, Event: PullRequestCommentEditedPayload{PullRequest: pr, Comment: Comment{ID: 9, Version: 2}}}, Expected: "pr:comment:edited/42/7/comment/9@2"},
+		{Name: "payload hash", Delivery: &Delivery{EventKey: EventRepoRefsChanged, Event: RepoRefsChangedPayload{}, Raw: []byte("{}")}, Expected: "repo:refs_changed/sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+		{Name: "ping", Delivery: &Delivery{EventKey: EventDiagnosticsPing, RequestID: "abc", Event: DiagnosticPingEvent{}}, Expected: ""},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		if key := IdempotencyKey(tt.Delivery); key != tt.Expected {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.Expected, key)
+		}
+	}
+}
+
+func TestIdempotentHandlers(t *testing.T) {
+	const body = `{"eventKey": "pr:opened", "pullRequest": {"id": 1, "version": 0}}`
+
+	calls := make([]int, 3)
+	fail := true
+
+	hook := New(WithIdempotency(NewMemoryIdempotencyStore(time.Hour)))
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls[0]++
+		return nil
+	})
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls[1]++
+		if fail {
+			return errors.New("build server unavailable")
+		}
+		return nil
+	})
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls[2]++
+		return nil
+	})
+
+	tc := []struct {
+		Name           string
+		RequestID      string
+		Fail           bool
+		ExpectedStatus int
+		ExpectedCalls  []int
+	}{
+		{Name: "first delivery fails in the second handler", RequestID: "1", Fail: true, ExpectedStatus: http.StatusInternalServerError, ExpectedCalls: []int{1, 1, 0}},
+		{Name: "retry only runs the remaining handlers", RequestID: "1", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{1, 2, 1}},
+		{Name: "second retry runs no handlers", RequestID: "1", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{1, 2, 1}},
+		{Name: "new delivery runs every handler", RequestID: "2", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{2, 3, 2}},
+		{Name: "delivery without a request ID", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{3, 4, 3}},
+		{Name: "same pull request version without a request ID", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{3, 4, 3}},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		fail = tt.Fail
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		if tt.RequestID != "" {
+			req.Header.Set("X-Request-Id", tt.RequestID)
+		}
+
+		rec := httptest.NewRecorder()
+		hook.ServeHTTP(rec, req)
+
+		if rec.Code != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, rec.Code)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.ExpectedCalls) {
+			t.Errorf("%s: Expected calls: %v, Got: %v", tt.Name, tt.ExpectedCalls, calls)
+		}
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := NewMemoryIdempotencyStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	if err := store.Complete(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tc := []struct {
+		Name     string
+		Key      string
+		Advance  time.Duration
+		Expected bool
+	}{
+		{Name: "completed key", Key: "a", Expected: true},
+		{Name: "unknown key", Key: "b"},
+		{Name: "expired key", Key: "a", Advance: 2 * time.Minute},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		now = now.Add(tt.Advance)
+
+		completed, err := store.Completed(ctx, tt.Key)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+		if completed != tt.Expected {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.Expected, completed)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package bitbucket
+
+import "net/http"
+
+// Logger receives structured log records from a Webhook. Each record is a message followed by alternating keys and
+// values. A *slog.Logger satisfies this interface, and adapters for other logging libraries are a few lines long.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// WithLogger sets the Logger that records the receipt of each request, the outcome of its signature verification
+// and any error decoding or handling it. Headers that carry credentials are redacted. Nothing is logged by default.
+func WithLogger(logger Logger) Option {
+	return func(w *Webhook) {
+		w.logger = logger
+	}
+}
+
+// redactedHeaders are the headers whose values are never logged
+var redactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Hub-Signature",
+}
+
+// redactHeader returns a copy of header with the values of credential headers replaced
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = []string{"REDACTED"}
+		}
+	}
+
+	return redacted
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
@@ -0,0 +1,131 @@
+package bitbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logRecord struct {
+	Level         string
+	Msg           string
+	KeysAndValues []interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (l *recordingLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, logRecord{Level: level, Msg: msg, KeysAndValues: keysAndValues})
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("debug", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("info", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("warn", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("error", msg, keysAndValues)
+}
+
+func (l *recordingLogger) messages() []string {
+	var msgs []string
+	for _, r := range l.records {
+		msgs = append(msgs, r.Level+": "+r.Msg)
+	}
+	return msgs
+}
+
+func TestLogger(t *testing.T) {
+	const (
+		secret = "secret"
+		body   = `{"eventKey": "pr:opened"}`
+	)
+
+	tc := []struct {
+		Name      string
+		Body      string
+		Signature string
+		Expected  []string
+	}{
+		{
+			Name:      "verified request",
+			Body:      body,
+			Signature: signature(secret, body),
+			Expected:  []string{"debug: webhook request received", "debug: signature verified"},
+		},
+		{
+			Name:      "invalid signature",
+			Body:      body,
+			Signature: signature("wrong", body),
+			Expected:  []string{"debug: webhook request received", "warn: signature verification failed"},
+		},
+		{
+			Name:      "invalid payload",
+			Body:      `{"eventKey":`,
+			Signature: signature(secret, `{"eventKey":`),
+			Expected:  []string{"debug: webhook request received", "debug: signature verified", "warn: could not decode payload"},
+		},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		logger := &recordingLogger{}
+		hook := New(WithSecret(secret), WithLogger(logger))
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.Body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		req.Header.Set("X-Hub-Signature", tt.Signature)
+		req.Header.Set("Authorization", "Bearer token")
+
+		hook.Parse(req)
+
+		if fmt.Sprint(logger.messages()) != fmt.Sprint(tt.Expected) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.Expected, logger.messages())
+		}
+
+		for _, r := range logger.records {
+			if len(r.KeysAndValues)%2 != 0 {
+				t.Errorf("%s: Expected keys and values in pairs, Got: %v", tt.Name, r.KeysAndValues)
+			}
+			logged := fmt.Sprint(r.KeysAndValues...)
+			if strings.Contains(logged, tt.Signature) || strings.Contains(logged, "Bearer token") {
+				t.Errorf("%s: Expected credentials to be redacted, Got: %s", tt.Name, logged)
+			}
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Hub-Signature", "sha256=abcd")
+	header.Set("Cookie", "session=1")
+	header.Set("X-Event-Key", "pr:opened")
+
+	redacted := redactHeader(header)
+
+	if redacted.Get("X-Hub-Signature") != "REDACTED" || redacted.Get("Cookie") != "REDACTED" {
+		t.Errorf("Expected credentials to be redacted, Got: %v", redacted)
+	}
+	if redacted.Get("X-Event-Key") != "pr:opened" {
+		t.Errorf("Expected: %s, Got: %s", "pr:opened", redacted.Get("X-Event-Key"))
+	}
+	if header.Get("X-Hub-Signature") != "sha256=abcd" {
+		t.Errorf("Expected the original header to be unchanged")
+	}
+}
@@ -0,0 +1,120 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps an EventHandler to add behaviour such as logging or panic recovery around it
+type Middleware func(next EventHandler) EventHandler
+
+// Use adds middleware that wraps every handler of the Webhook. Middleware added first is the outermost, and global
+// middleware wraps the middleware added with UseFor. Middleware applies to handlers registered before and after it.
+func (hook *Webhook) Use(middleware ...Middleware) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.middleware = append(hook.middleware, middleware...)
+}
+
+// UseFor adds middleware that wraps the handlers of an event key
+func (hook *Webhook) UseFor(key EventKey, middleware ...Middleware) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	if hook.keyMiddleware == nil {
+		hook.keyMiddleware = make(map[EventKey][]Middleware)
+	}
+	hook.keyMiddleware[key] = append(hook.keyMiddleware[key], middleware...)
+}
+
+// handlersFor returns the handlers registered for key, each wrapped in the middleware that applies to key
+func (hook *Webhook) handlersFor(key EventKey) []EventHandler {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	handlers := hook.handlers[key]
+	chain := append(append([]Middleware{}, hook.middleware...), hook.keyMiddleware[key]...)
+	if len(chain) == 0 {
+		return handlers
+	}
+
+	wrapped := make([]EventHandler, len(handlers))
+	for i, handler := range handlers {
+		for j := len(chain) - 1; j >= 0; j-- {
+			handler = chain[j](handler)
+		}
+		wrapped[i] = handler
+	}
+
+	return wrapped
+}
+
+// Recover returns middleware that turns a panic in a handler into an error wrapping ErrHandlerPanic, which includes
+// the stack trace of the panic
+func Recover() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%w: %v\n%s", ErrHandlerPanic, r, debug.Stack())
+				}
+			}()
+
+			return next(ctx, event)
+		}
+	}
+}
+
+// Timeout returns middleware that cancels the context passed to a handler after d. Handlers must stop when their
+// context is done for the timeout to take effect.
+func Timeout(d time.Duration) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			return next(ctx, event)
+		}
+	}
+}
+
+// Logging returns middleware that logs the outcome and duration of each handler call
+func Logging(logger Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			var requestID string
+			if d, ok := DeliveryFromContext(ctx); ok {
+				requestID = d.RequestID
+			}
+
+			start := time.Now()
+			err := next(ctx, event)
+			duration := time.Since(start)
+
+			if err != nil {
+				logger.Error("event handler failed", "event_key", event.Key(), "request_id", requestID, "duration", duration, "error", err)
+				return err
+			}
+
+			logger.Info("event handled", "event_key", event.Key(), "request_id", requestID, "duration", duration)
+			return nil
+		}
+	}
+}
+
+// Filter returns middleware that only calls a handler when match returns true. Events that do not match are
+// skipped without an error.
+func Filter(match func(ctx context.Context, event Event) bool) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			if !match(ctx, event) {
+				return nil
+			}
+
+			return next(ctx, event)
+		}
+	}
+}
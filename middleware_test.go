@@ -0,0 +1,138 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next EventHandler) EventHandler {
+			return func(ctx context.Context, event Event) error {
+				calls = append(calls, name+" before")
+				err := next(ctx, event)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	hook := New()
+	hook.Use(trace("global 1"), trace("global 2"))
+	hook.UseFor(EventPullRequestOpened, trace("pr:opened"))
+	hook.UseFor(EventPullRequestMerged, trace("pr:merged"))
+
+	// Middleware also applies to handlers registered after it
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	if err := hook.dispatch(context.Background(), &Delivery{EventKey: EventPullRequestOpened, Event: PullRequestOpenedPayload{}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"global 1 before", "global 2 before", "pr:opened before", "handler", "pr:opened after", "global 2 after", "global 1 after"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, calls)
+	}
+}
+
+func TestBuiltinMiddleware(t *testing.T) {
+	event := PullRequestOpenedPayload{PullRequest: PullRequest{Draft: true}}
+
+	tc := []struct {
+		Name        string
+		Middleware  Middleware
+		Handler     EventHandler
+		ExpectedErr error
+	}{
+		{
+			Name:       "recover from panic",
+			Middleware: Recover(),
+			Handler: func(ctx context.Context, event Event) error {
+				panic("nil map")
+			},
+			ExpectedErr: ErrHandlerPanic,
+		},
+		{
+			Name:       "recover passes errors through",
+			Middleware: Recover(),
+			Handler: func(ctx context.Context, event Event) error {
+				return context.Canceled
+			},
+			ExpectedErr: context.Canceled,
+		},
+		{
+			Name:       "timeout",
+			Middleware: Timeout(time.Millisecond),
+			Handler: func(ctx context.Context, event Event) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			ExpectedErr: context.DeadlineExceeded,
+		},
+		{
+			Name: "filter skips events that do not match",
+			Middleware: Filter(func(ctx context.Context, event Event) bool {
+				return !event.(PullRequestOpenedPayload).Draft
+			}),
+			Handler: func(ctx context.Context, event Event) error {
+				return errors.New("handler called")
+			},
+		},
+		{
+			Name: "filter calls the handler for events that match",
+			Middleware: Filter(func(ctx context.Context, event Event) bool {
+				return event.(PullRequestOpenedPayload).Draft
+			}),
+			Handler: func(ctx context.Context, event Event) error {
+				return errors.New("handler called")
+			},
+			ExpectedErr: errors.New("handler called"),
+		},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		err := tt.Middleware(tt.Handler)(context.Background(), event)
+
+		switch {
+		case tt.ExpectedErr == nil && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+		case tt.ExpectedErr != nil && err == nil:
+			t.Errorf("%s: Expected: %v, Got: nil", tt.Name, tt.ExpectedErr)
+		case tt.ExpectedErr != nil && !errors.Is(err, tt.ExpectedErr) && err.Error() != tt.ExpectedErr.Error():
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	logger := &recordingLogger{}
+
+	hook := New()
+	hook.UseFor(EventPullRequestOpened, Logging(logger))
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		return nil
+	})
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		return errors.New("build server unavailable")
+	})
+
+	d := &Delivery{EventKey: EventPullRequestOpened, RequestID: "1", Event: PullRequestOpenedPayload{commonBitbucketEventFields: commonBitbucketEventFields{EventKey: "pr:opened"}}}
+	hook.dispatch(contextWithDelivery(context.Background(), d), d)
+
+	expected := []string{"info: event handled", "error: event handler failed"}
+	if fmt.Sprint(logger.messages()) != fmt.Sprint(expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, logger.messages())
+	}
+	if len(logger.records) > 0 && !strings.Contains(fmt.Sprint(logger.records[0].KeysAndValues...), "request_id1") {
+		t.Errorf("Expected the request ID to be logged, Got: %v", logger.records[0].KeysAndValues)
+	}
+}
@@ -0,0 +1,36 @@
+package bitbucket
+
+import "fmt"
+
+// pullRequestEvent is implemented by the payloads that embed a PullRequest
+type pullRequestEvent interface {
+	orderingPullRequest() PullRequest
+}
+
+func (pr PullRequest) orderingPullRequest() PullRequest {
+	return pr
+}
+
+// OrderingKey returns the key of the entity an event belongs to. Events with the same key must be handled in the
+// order they occurred:
+//
+//	pull request events  repo/<repository ID>/pr/<pull request ID>
+//	repository events    repo/<repository ID>
+//	project events       project/<project key>
+//
+// "repo:refs_changed" events are keyed by their repository, as a single push can change several refs and must be
+// ordered with every other push to any of them. An empty key is returned for events that do not belong to an
+// entity, such as "diagnostics:ping".
+func OrderingKey(event Event) string {
+	switch e := event.(type) {
+	case pullRequestEvent:
+		pr := e.orderingPullRequest()
+		return fmt.Sprintf("repo/%d/pr/%d", pr.ToRef.Repository.ID, pr.ID)
+	case RepositoryEvent:
+		return fmt.Sprintf("repo/%d", e.EventRepository().ID)
+	case ProjectEvent:
+		return "project/" + e.EventProject().Key
+	}
+
+	return ""
+}
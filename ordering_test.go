@@ -0,0 +1,98 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderingKey(t *testing.T) {
+	pr := PullRequest{ID: 7}
+	pr.ToRef.Repository.ID = 42
+
+	refs := RepoRefsChangedPayload{Repository: Repository{ID: 42}, Changes: []Changes{{RefID: "refs/heads/main"}}}
+	multipleRefs := RepoRefsChangedPayload{Repository: Repository{ID: 42}, Changes: []Changes{{RefID: "refs/heads/main"}, {RefID: "refs/tags/v1"}}}
+
+	tc := []struct {
+		Name     string
+		Event    Event
+		Expected string
+	}{
+		{Name: "pr:opened", Event: PullRequestOpenedPayload{PullRequest: pr}, Expected: "repo/42/pr/7"},
+		{Name: "pr:reviewer:approved", Event: PullRequestReviewerPayload{PullRequest: pr}, Expected: "repo/42/pr/7"},
+		{Name: "pr:comment:added", Event: PullRequestCommentAddedPayload{PullRequest: pr}, Expected: "repo/42/pr/7"},
+		{Name: "repo:refs_changed", Event: refs, Expected: "repo/42"},
+		{Name: "repo:refs_changed with several refs", Event: multipleRefs, Expected: "repo/42"},
+		{Name: "repo:modified", Event: RepoModifiedPayload{NewVersion: RepoVersion{ID: 42}}, Expected: "repo/42"},
+		{Name: "repo:comment:added", Event: RepoCommentAddedPayload{Repository: Repository{ID: 42}}, Expected: "repo/42"},
+		{Name: "project:modified", Event: ProjectModifiedPayload{NewVersion: Project{Key: "SEC"}}, Expected: "project/SEC"},
+		{Name: "diagnostics:ping", Event: DiagnosticPingEvent{}, Expected: ""},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		if key := OrderingKey(tt.Event); key != tt.Expected {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.Expected, key)
+		}
+	}
+}
+
+func TestDispatcherOrdering(t *testing.T) {
+	const (
+		pullRequests = 8
+		events       = 20
+	)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[uint64][]uint64)
+		running  = make(map[uint64]bool)
+	)
+
+	hook := New()
+	hook.OnPullRequestModified(func(ctx context.Context, pl PullRequestModifiedPayload) error {
+		mu.Lock()
+		if running[pl.PullRequest.ID] {
+			t.Errorf("Expected events for pull request %d to be handled one at a time", pl.PullRequest.ID)
+		}
+		running[pl.PullRequest.ID] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		running[pl.PullRequest.ID] = false
+		received[pl.PullRequest.ID] = append(received[pl.PullRequest.ID], pl.PullRequest.Version)
+		return nil
+	})
+
+	d := NewDispatcher(hook, WithWorkers(4), WithQueueSize(pullRequests*events), WithOrdering())
+
+	for version := uint64(0); version < events; version++ {
+		for id := uint64(1); id <= pullRequests; id++ {
+			pl := PullRequestModifiedPayload{PullRequest: PullRequest{ID: id, Version: version}}
+			if err := d.Enqueue(&Delivery{EventKey: EventPullRequestModified, Event: pl}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for id, versions := range received {
+		if len(versions) != events {
+			t.Errorf("pull request %d: Expected: %d events, Got: %d", id, events, len(versions))
+		}
+		for i, version := range versions {
+			if version != uint64(i) {
+				t.Errorf("pull request %d: Expected versions in order, Got: %v", id, versions)
+				break
+			}
+		}
+	}
+}
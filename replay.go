@@ -0,0 +1,388 @@
+package bitbucket
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeliveryStore records the deliveries a Webhook has accepted, so that replayed requests can be rejected
+type DeliveryStore interface {
+	// Record stores key until ttl has passed and reports whether key was already stored and has not expired
+	Record(ctx context.Context, key string, ttl time.Duration) (duplicate bool, err error)
+
+	// Forget removes key, so that a delivery whose handlers failed can be delivered again
+	Forget(ctx context.Context, key string) error
+}
+
+// WithReplayProtection rejects deliveries that have already been accepted within ttl. Each delivery is recorded in
+// store by its X-Request-Id header and by a hash of its payload, and a delivery matching either is rejected with
+// ErrDuplicateDelivery. When a handler fails, ServeHTTP forgets the delivery again so that Bitbucket can retry it;
+// callers of Parse do the same with ReleaseDelivery. "diagnostics:ping" events are not recorded, as Bitbucket sends
+// the same payload for every ping.
+func WithReplayProtection(store DeliveryStore, ttl time.Duration) Option {
+	return func(w *Webhook) {
+		w.deliveryStore = store
+		w.replayTTL = ttl
+	}
+}
+
+// WithMaxAge rejects events whose date is more than maxAge before the time they are received with ErrDeliveryTooOld.
+// Events without a date are not checked.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(w *Webhook) {
+		w.maxAge = maxAge
+	}
+}
+
+// checkReplay applies the maximum age and replay protection of the webhook to a delivery
+func (hook *Webhook) checkReplay(ctx context.Context, d *Delivery) error {
+	if hook.maxAge > 0 {
+		date := d.Event.Date()
+		if !date.IsZero() && d.ReceivedAt.Sub(date) > hook.maxAge {
+			return fmt.Errorf("%w: event date %s is older than %s", ErrDeliveryTooOld, date.Format(time.RFC3339), hook.maxAge)
+		}
+	}
+
+	if hook.deliveryStore == nil {
+		return nil
+	}
+
+	idKey, hashKey := replayKeys(d)
+
+	if idKey != "" {
+		duplicate, err := hook.deliveryStore.Record(ctx, idKey, hook.replayTTL)
+		if err != nil {
+			return fmt.Errorf("%w: could not record delivery: %v", ErrDeliveryStore, err)
+		}
+		if duplicate {
+			return fmt.Errorf("%w: request ID '%s' was already received", ErrDuplicateDelivery, d.RequestID)
+		}
+	}
+
+	duplicate, err := hook.deliveryStore.Record(ctx, hashKey, hook.replayTTL)
+	if err == nil && !duplicate {
+		return nil
+	}
+
+	// The delivery is rejected, so the request ID recorded above must not block a later delivery
+	if idKey != "" {
+		if forgetErr := hook.deliveryStore.Forget(ctx, idKey); forgetErr != nil {
+			hook.logger.Error("could not forget delivery", "request_id", d.RequestID, "error", forgetErr)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: could not record delivery: %v", ErrDeliveryStore, err)
+	}
+	return fmt.Errorf("%w: payload was already received", ErrDuplicateDelivery)
+}
+
+// replayKeys returns the keys a delivery is recorded by. idKey is empty when the request has no X-Request-Id header.
+func replayKeys(d *Delivery) (idKey, hashKey string) {
+	if d.RequestID != "" {
+		idKey = "id:" + d.RequestID
+	}
+	sum := sha256.Sum256(d.Raw)
+	hashKey = "sha256:" + hex.EncodeToString(sum[:])
+
+	return idKey, hashKey
+}
+
+// ReleaseDelivery forgets a delivery recorded by replay protection, so that it is accepted when Bitbucket delivers it
+// again. It must be called when a delivery returned by Parse or ParseDelivery could not be processed.
+func (hook *Webhook) ReleaseDelivery(ctx context.Context, d *Delivery) error {
+	if hook.deliveryStore == nil || d.EventKey == EventDiagnosticsPing {
+		return nil
+	}
+
+	idKey, hashKey := replayKeys(d)
+	if idKey != "" {
+		if err := hook.deliveryStore.Forget(ctx, idKey); err != nil {
+			return fmt.Errorf("%w: could not forget delivery: %v", ErrDeliveryStore, err)
+		}
+	}
+	if err := hook.deliveryStore.Forget(ctx, hashKey); err != nil {
+		return fmt.Errorf("%w: could not forget delivery: %v", ErrDeliveryStore, err)
+	}
+
+	return nil
+}
+
+// releaseDelivery calls ReleaseDelivery and logs its error
+func (hook *Webhook) releaseDelivery(ctx context.Context, d *Delivery) {
+	if err := hook.ReleaseDelivery(ctx, d); err != nil {
+		hook.logger.Error("could not release delivery", "event_key", d.EventKey, "request_id", d.RequestID, "error", err)
+	}
+}
+
+// replayStatus returns the HTTP status for an error returned by checkReplay
+func replayStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrDuplicateDelivery):
+		return http.StatusConflict
+	case errors.Is(err, ErrDeliveryTooOld):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// MemoryDeliveryStore is an in-memory DeliveryStore. It holds at most capacity keys; when it is full the least
+// recently recorded key is evicted, even if it has not expired. It is safe for concurrent use.
+type MemoryDeliveryStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type storedDelivery struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryDeliveryStore creates a MemoryDeliveryStore that holds at most capacity keys
+func NewMemoryDeliveryStore(capacity int) *MemoryDeliveryStore {
+	return &MemoryDeliveryStore{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Record implements DeliveryStore
+func (s *MemoryDeliveryStore) Record(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.entries[key]; ok {
+		entry := el.Value.(*storedDelivery)
+		if now.Before(entry.expires) {
+			return true, nil
+		}
+		entry.expires = now.Add(ttl)
+		s.order.MoveToFront(el)
+		return false, nil
+	}
+
+	for s.capacity > 0 && s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*storedDelivery).key)
+	}
+
+	s.entries[key] = s.order.PushFront(&storedDelivery{key: key, expires: now.Add(ttl)})
+	return false, nil
+}
+
+// Forget implements DeliveryStore
+func (s *MemoryDeliveryStore) Forget(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.order.Remove(el)
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+// FileDeliveryStore is a DeliveryStore that appends each key to a file, so that deliveries are remembered across
+// restarts. Expired keys are pruned while the store is in use, and the file is rewritten without them once most of
+// its lines are stale. It is safe for concurrent use.
+type FileDeliveryStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	entries   map[string]time.Time
+	lines     int
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// fileDeliveryStorePruneInterval is how often expired keys are removed from a FileDeliveryStore
+const fileDeliveryStorePruneInterval = time.Minute
+
+// OpenFileDeliveryStore opens or creates the file at path and loads the keys that have not expired
+func OpenFileDeliveryStore(path string) (*FileDeliveryStore, error) {
+	s := &FileDeliveryStore{
+		path:    path,
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+
+	now := s.now()
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			expires, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			key, err := strconv.Unquote(fields[1])
+			if err != nil {
+				continue
+			}
+			// Later lines override earlier ones, and a line that has already expired forgets the key
+			if t := time.Unix(0, expires); t.After(now) {
+				s.entries[key] = t
+			} else {
+				delete(s.entries, key)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read delivery store: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not open delivery store: %w", err)
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	s.lastPrune = now
+
+	return s, nil
+}
+
+// compact rewrites the file with only the keys in memory and reopens it for appending
+func (s *FileDeliveryStore) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not compact delivery store: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for key, expires := range s.entries {
+		fmt.Fprintf(w, "%d %s\n", expires.UnixNano(), strconv.Quote(key))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact delivery store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact delivery store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact delivery store: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open delivery store: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.lines = len(s.entries)
+
+	return nil
+}
+
+// prune removes expired keys at most once per fileDeliveryStorePruneInterval, and compacts the file when more than
+// half of its lines are stale
+func (s *FileDeliveryStore) prune(now time.Time) error {
+	if now.Sub(s.lastPrune) < fileDeliveryStorePruneInterval {
+		return nil
+	}
+	s.lastPrune = now
+
+	for key, expires := range s.entries {
+		if !now.Before(expires) {
+			delete(s.entries, key)
+		}
+	}
+
+	if s.lines > 2*len(s.entries) {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// write appends a key and its expiry to the file
+func (s *FileDeliveryStore) write(key string, expires time.Time) error {
+	if _, err := fmt.Fprintf(s.file, "%d %s\n", expires.UnixNano(), strconv.Quote(key)); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.lines++
+
+	return nil
+}
+
+// Record implements DeliveryStore
+func (s *FileDeliveryStore) Record(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if err := s.prune(now); err != nil {
+		return false, err
+	}
+
+	if expires, ok := s.entries[key]; ok && now.Before(expires) {
+		return true, nil
+	}
+
+	expires := now.Add(ttl)
+	if err := s.write(key, expires); err != nil {
+		return false, err
+	}
+
+	s.entries[key] = expires
+	return false, nil
+}
+
+// Forget implements DeliveryStore
+func (s *FileDeliveryStore) Forget(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+
+	// A line that has already expired removes the key when the file is loaded
+	if err := s.write(key, time.Unix(0, 0)); err != nil {
+		return err
+	}
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Close closes the file of the store
+func (s *FileDeliveryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,293 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayProtection(t *testing.T) {
+	const (
+		first  = `{"eventKey": "pr:opened", "date": "2022-09-14T05:40:02+0000"}`
+		second = `{"eventKey": "pr:opened", "date": "2022-09-14T05:40:03+0000"}`
+	)
+
+	hook := New(WithReplayProtection(NewMemoryDeliveryStore(100), time.Hour))
+
+	tc := []struct {
+		Name        string
+		Body        string
+		RequestID   string
+		EventKey    string
+		ExpectedErr error
+	}{
+		{Name: "first delivery", Body: first, RequestID: "1", EventKey: "pr:opened"},
+		{Name: "same request ID", Body: second, RequestID: "1", EventKey: "pr:opened", ExpectedErr: ErrDuplicateDelivery},
+		{Name: "same payload with new request ID", Body: first, RequestID: "2", EventKey: "pr:opened", ExpectedErr: ErrDuplicateDelivery},
+		{Name: "same payload without request ID", Body: first, EventKey: "pr:opened", ExpectedErr: ErrDuplicateDelivery},
+		{Name: "request ID of a rejected delivery is not recorded", Body: second, RequestID: "2", EventKey: "pr:opened"},
+		{Name: "first ping", Body: `{"test": true}`, EventKey: "diagnostics:ping"},
+		{Name: "second ping", Body: `{"test": true}`, EventKey: "diagnostics:ping"},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.Body))
+		req.Header.Set("X-Event-Key", tt.EventKey)
+		if tt.RequestID != "" {
+			req.Header.Set("X-Request-Id", tt.RequestID)
+		}
+
+		_, err := hook.Parse(req)
+		if !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+		if err != nil && tt.RequestID == "" && strings.Contains(err.Error(), "request ID") {
+			t.Errorf("%s: Expected the error to report the payload, Got: %v", tt.Name, err)
+		}
+	}
+}
+
+func TestReplayProtectionWithFailingHandler(t *testing.T) {
+	const body = `{"eventKey": "pr:opened", "pullRequest": {"id": 1}}`
+
+	calls := make([]int, 2)
+	fail := true
+
+	hook := New(
+		WithReplayProtection(NewMemoryDeliveryStore(100), time.Hour),
+		WithIdempotency(NewMemoryIdempotencyStore(time.Hour)),
+	)
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls[0]++
+		return nil
+	})
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		calls[1]++
+		if fail {
+			return errors.New("build server unavailable")
+		}
+		return nil
+	})
+
+	tc := []struct {
+		Name           string
+		Fail           bool
+		ExpectedStatus int
+		ExpectedCalls  []int
+	}{
+		{Name: "first delivery fails", Fail: true, ExpectedStatus: http.StatusInternalServerError, ExpectedCalls: []int{1, 1}},
+		{Name: "retry runs the failed handler", ExpectedStatus: http.StatusOK, ExpectedCalls: []int{1, 2}},
+		{Name: "replay of a handled delivery", ExpectedStatus: http.StatusConflict, ExpectedCalls: []int{1, 2}},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		fail = tt.Fail
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		req.Header.Set("X-Request-Id", "1")
+		rec := httptest.NewRecorder()
+
+		hook.ServeHTTP(rec, req)
+
+		if rec.Code != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, rec.Code)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.ExpectedCalls) {
+			t.Errorf("%s: Expected calls: %v, Got: %v", tt.Name, tt.ExpectedCalls, calls)
+		}
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	now := time.Now().UTC()
+
+	tc := []struct {
+		Name        string
+		Date        string
+		ExpectedErr error
+	}{
+		{Name: "recent event", Date: now.Add(-time.Minute).Format(bitbucketDateLayout)},
+		{Name: "old event", Date: now.Add(-2 * time.Hour).Format(bitbucketDateLayout), ExpectedErr: ErrDeliveryTooOld},
+		{Name: "event without a date"},
+	}
+
+	hook := New(WithMaxAge(time.Hour))
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		body := `{"eventKey": "pr:opened"}`
+		if tt.Date != "" {
+			body = fmt.Sprintf(`{"eventKey": "pr:opened", "date": "%s"}`, tt.Date)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+
+		_, err := hook.Parse(req)
+		if !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+	}
+}
+
+func TestMemoryDeliveryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := NewMemoryDeliveryStore(2)
+	store.now = func() time.Time { return now }
+
+	tc := []struct {
+		Name      string
+		Key       string
+		Advance   time.Duration
+		Duplicate bool
+	}{
+		{Name: "new key", Key: "a"},
+		{Name: "duplicate key", Key: "a", Duplicate: true},
+		{Name: "second key", Key: "b"},
+		{Name: "third key evicts the oldest", Key: "c"},
+		{Name: "evicted key", Key: "a"},
+		{Name: "expired key", Key: "c", Advance: 2 * time.Minute},
+		{Name: "key recorded again after expiring", Key: "c", Duplicate: true},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		now = now.Add(tt.Advance)
+
+		duplicate, err := store.Record(ctx, tt.Key, time.Minute)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+		if duplicate != tt.Duplicate {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.Duplicate, duplicate)
+		}
+	}
+}
+
+func TestFileDeliveryStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "deliveries")
+
+	store, err := OpenFileDeliveryStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"id:1", "id:with spaces\nand newlines"} {
+		if duplicate, err := store.Record(ctx, key, time.Hour); err != nil || duplicate {
+			t.Errorf("Expected new key %q, Got: duplicate %v, error %v", key, duplicate, err)
+		}
+	}
+	if _, err := store.Record(ctx, "id:expired", -time.Second); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store, err = OpenFileDeliveryStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	tc := []struct {
+		Name      string
+		Key       string
+		Duplicate bool
+	}{
+		{Name: "key recorded before reopening", Key: "id:1", Duplicate: true},
+		{Name: "key with special characters", Key: "id:with spaces\nand newlines", Duplicate: true},
+		{Name: "expired key", Key: "id:expired"},
+		{Name: "new key", Key: "id:2"},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		duplicate, err := store.Record(ctx, tt.Key, time.Hour)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+		if duplicate != tt.Duplicate {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.Duplicate, duplicate)
+		}
+	}
+}
+
+func TestFileDeliveryStorePruning(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "deliveries")
+
+	store, err := OpenFileDeliveryStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		if _, err := store.Record(ctx, fmt.Sprintf("id:%d", i), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := store.Record(ctx, "id:live", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Forget(ctx, "id:live"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Record(ctx, "id:kept", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fmt.Println("Test: expired keys are pruned and the file is compacted")
+	now = now.Add(2 * fileDeliveryStorePruneInterval)
+	if _, err := store.Record(ctx, "id:new", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(store.entries) != 2 {
+		t.Errorf("Expected: %d keys, Got: %d", 2, len(store.entries))
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected: %d lines, Got: %d", 2, lines)
+	}
+
+	fmt.Println("Test: forgotten keys stay forgotten after reopening")
+	if err := store.Forget(ctx, "id:kept"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenFileDeliveryStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if duplicate, err := store.Record(ctx, "id:kept", time.Hour); err != nil || duplicate {
+		t.Errorf("Expected a forgotten key to be recorded again, Got: duplicate %v, error %v", duplicate, err)
+	}
+	if duplicate, err := store.Record(ctx, "id:new", time.Hour); err != nil || !duplicate {
+		t.Errorf("Expected a recorded key to be a duplicate, Got: duplicate %v, error %v", duplicate, err)
+	}
+}
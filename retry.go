@@ -0,0 +1,73 @@
+package bitbucket
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy sets how often a Dispatcher calls the handlers of a delivery that failed. The wait before each retry
+// grows exponentially from InitialBackoff by Multiplier up to MaxBackoff, and is shortened by a random fraction of up
+// to Jitter so that retries of many failed deliveries are spread out. The zero RetryPolicy does not retry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the handlers are called, including the first attempt
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the wait before a retry. There is no limit when it is zero.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the wait grows by after each retry. A Multiplier of zero is treated as 2.
+	Multiplier float64
+
+	// Jitter is the largest fraction, between 0 and 1, that the wait is randomly shortened by
+	Jitter float64
+}
+
+// DefaultRetryPolicy makes up to 5 attempts, waiting about 1s, 2s, 4s and 8s between them
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// WithRetryPolicy retries the handlers of a delivery that failed according to policy. Handlers that succeeded are
+// called again on every retry unless the Webhook uses WithIdempotency. With WithOrdering, later deliveries for the
+// same entity wait until the retries are done.
+func WithRetryPolicy(policy RetryPolicy) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.retryPolicy = policy
+	}
+}
+
+// Backoff returns the wait before retry number attempt, starting at 1
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	// Without a MaxBackoff the backoff can grow past the longest time.Duration
+	if backoff > math.MaxInt64 {
+		backoff = math.MaxInt64
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		backoff -= backoff * jitter * rand.Float64()
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit in a time.Duration
+	if backoff >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,130 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	tc := []struct {
+		Name     string
+		Attempt  int
+		Expected time.Duration
+	}{
+		{Name: "first retry", Attempt: 1, Expected: time.Second},
+		{Name: "second retry", Attempt: 2, Expected: 2 * time.Second},
+		{Name: "third retry", Attempt: 3, Expected: 4 * time.Second},
+		{Name: "capped retry", Attempt: 4, Expected: 5 * time.Second},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		if backoff := policy.Backoff(tt.Attempt); backoff != tt.Expected {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.Expected, backoff)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if backoff := policy.Backoff(2); backoff < time.Second || backoff > 2*time.Second {
+			t.Fatalf("Expected a backoff between 1s and 2s, Got: %s", backoff)
+		}
+	}
+
+	fmt.Println("Test: uncapped backoff does not overflow")
+	uncapped := RetryPolicy{InitialBackoff: time.Second, Multiplier: 2}
+	for _, attempt := range []int{64, 100, 2000} {
+		if backoff := uncapped.Backoff(attempt); backoff != time.Duration(math.MaxInt64) {
+			t.Errorf("Expected: %s, Got: %s", time.Duration(math.MaxInt64), backoff)
+		}
+	}
+	uncapped.Jitter = 0.5
+	if backoff := uncapped.Backoff(2000); backoff < time.Duration(math.MaxInt64/2) {
+		t.Errorf("Expected a backoff of at least %s, Got: %s", time.Duration(math.MaxInt64/2), backoff)
+	}
+}
+
+type memoryDeadLetterSink struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (s *memoryDeadLetterSink) Put(ctx context.Context, letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tc := []struct {
+		Name                string
+		Failures            int
+		Panic               bool
+		ExpectedCalls       int
+		ExpectedDeadLetters int
+	}{
+		{Name: "handler succeeds", Failures: 0, ExpectedCalls: 1},
+		{Name: "handler succeeds on retry", Failures: 2, ExpectedCalls: 3},
+		{Name: "handler fails every attempt", Failures: 10, ExpectedCalls: 4, ExpectedDeadLetters: 1},
+		{Name: "handler panics and succeeds on retry", Failures: 1, Panic: true, ExpectedCalls: 2},
+		{Name: "handler panics every attempt", Failures: 10, Panic: true, ExpectedCalls: 4, ExpectedDeadLetters: 1},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		calls := 0
+
+		hook := New()
+		hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+			calls++
+			if calls <= tt.Failures && tt.Panic {
+				panic("build server unavailable")
+			}
+			if calls <= tt.Failures {
+				return errors.New("build server unavailable")
+			}
+			return nil
+		})
+
+		sink := &memoryDeadLetterSink{}
+		d := NewDispatcher(hook,
+			WithWorkers(1),
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond}),
+			WithDeadLetterSink(sink),
+		)
+
+		if err := d.Enqueue(&Delivery{EventKey: EventPullRequestOpened, Event: PullRequestOpenedPayload{}, RequestID: "1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := d.Shutdown(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if calls != tt.ExpectedCalls {
+			t.Errorf("%s: Expected calls: %d, Got: %d", tt.Name, tt.ExpectedCalls, calls)
+		}
+		if len(sink.letters) != tt.ExpectedDeadLetters {
+			t.Errorf("%s: Expected dead letters: %d, Got: %d", tt.Name, tt.ExpectedDeadLetters, len(sink.letters))
+			continue
+		}
+		if tt.ExpectedDeadLetters > 0 {
+			letter := sink.letters[0]
+			if letter.Attempts != 4 || letter.RequestID != "1" || letter.Error == "" {
+				t.Errorf("%s: unexpected dead letter: %+v", tt.Name, letter)
+			}
+			if tt.Panic && !strings.HasPrefix(letter.Error, ErrHandlerPanic.Error()) {
+				t.Errorf("%s: Expected: %v, Got: %s", tt.Name, ErrHandlerPanic, letter.Error)
+			}
+		}
+	}
+}
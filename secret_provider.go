@@ -0,0 +1,379 @@
+package bitbucket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretQuery identifies the webhook that sent a request. It is built from the request headers and the unverified
+// payload, so a SecretProvider must only use it to choose which secrets to return.
+type SecretQuery struct {
+	// EventKey is the value of the X-Event-Key header
+	EventKey EventKey
+
+	// ProjectKey is the key of the project the event occurred in
+	ProjectKey string
+
+	// RepositorySlug is the slug of the repository the event occurred in. It is empty for project events.
+	RepositorySlug string
+
+	// WebhookID is the value of the X-Hook-UUID header, which is set by Bitbucket Cloud and some relays. Bitbucket
+	// Server and Data Center do not send it, and as it is not covered by the signature anyone can set it. It is only
+	// set for events that do not belong to a project, so that the secret of one webhook cannot sign the events of
+	// another project.
+	WebhookID string
+}
+
+// SecretProvider looks up the secrets accepted for a request. It allows a different secret to be used for each
+// project, repository or webhook. Returning no secrets causes signed requests to be rejected.
+type SecretProvider interface {
+	Secrets(ctx context.Context, query SecretQuery) ([]Secret, error)
+}
+
+// SecretProviderFunc is an adapter to allow the use of ordinary functions as a SecretProvider
+type SecretProviderFunc func(ctx context.Context, query SecretQuery) ([]Secret, error)
+
+// Secrets calls f(ctx, query)
+func (f SecretProviderFunc) Secrets(ctx context.Context, query SecretQuery) ([]Secret, error) {
+	return f(ctx, query)
+}
+
+// WithSecretProvider sets a SecretProvider that is consulted for every signed request. The secrets it returns are
+// accepted in addition to the secrets set by WithSecret and WithSecrets.
+func WithSecretProvider(provider SecretProvider) Option {
+	return func(w *Webhook) {
+		w.secretProvider = provider
+	}
+}
+
+// newSecretQuery builds a SecretQuery from the headers and unverified payload of a request. The project and
+// repository are taken from the decoded event, so the secret is chosen by the same fields Parse returns. An error
+// wrapping ErrInvalidPayload is returned when the payload names a different project or repository in another field,
+// as a signature made with the secret of one would otherwise be accepted for an event of the other.
+func newSecretQuery(header http.Header, payload []byte) (SecretQuery, error) {
+	query := SecretQuery{
+		EventKey: EventKey(header.Get("X-Event-Key")),
+	}
+
+	if query.EventKey != EventDiagnosticsPing {
+		// A payload that cannot be decoded is rejected by Parse once its signature has been checked
+		if event, err := decodeEvent(query.EventKey, payload); err == nil {
+			switch e := event.(type) {
+			case RepositoryEvent:
+				repo := e.EventRepository()
+				query.ProjectKey = repo.Project.Key
+				query.RepositorySlug = repo.Slug
+			case ProjectEvent:
+				query.ProjectKey = e.EventProject().Key
+			}
+		}
+	}
+
+	if err := checkSecretQuery(query, payload); err != nil {
+		return SecretQuery{}, err
+	}
+
+	if query.ProjectKey == "" {
+		query.WebhookID = header.Get("X-Hook-UUID")
+	}
+
+	return query, nil
+}
+
+// checkSecretQuery reports an error when a field of payload that identifies the project or repository of an event
+// names a different one than query
+func checkSecretQuery(query SecretQuery, payload []byte) error {
+	type project struct {
+		Key string `json:"key"`
+	}
+	type repository struct {
+		Slug    string  `json:"slug"`
+		Key     string  `json:"key"`
+		Project project `json:"project"`
+	}
+
+	var peek struct {
+		Repository  *repository `json:"repository"`
+		PullRequest *struct {
+			ToRef struct {
+				Repository repository `json:"repository"`
+			} `json:"toRef"`
+		} `json:"pullRequest"`
+		New *repository `json:"new"`
+	}
+
+	if err := json.Unmarshal(payload, &peek); err != nil {
+		return nil
+	}
+
+	var found []repository
+	if peek.Repository != nil {
+		found = append(found, *peek.Repository)
+	}
+	if peek.PullRequest != nil {
+		found = append(found, peek.PullRequest.ToRef.Repository)
+	}
+	if peek.New != nil && peek.New.Slug != "" {
+		found = append(found, *peek.New)
+	} else if peek.New != nil && peek.New.Key != "" {
+		// The "new" field of "project:modified" events is a project
+		found = append(found, repository{Project: project{Key: peek.New.Key}})
+	}
+
+	for _, repo := range found {
+		if repo.Project.Key != "" && repo.Project.Key != query.ProjectKey || repo.Slug != "" && repo.Slug != query.RepositorySlug {
+			return fmt.Errorf("%w: payload names project '%s' and repository '%s', but the event belongs to project '%s' and repository '%s'",
+				ErrInvalidPayload, repo.Project.Key, repo.Slug, query.ProjectKey, query.RepositorySlug)
+		}
+	}
+
+	return nil
+}
+
+// MemorySecretProvider is a SecretProvider backed by an in-memory map. Secrets are looked up by repository, then by
+// project, then by webhook ID for events that do not belong to a project, and finally the default secrets are used.
+// It is safe for concurrent use.
+type MemorySecretProvider struct {
+	mu      sync.RWMutex
+	secrets map[string][]Secret
+}
+
+// NewMemorySecretProvider creates an empty MemorySecretProvider
+func NewMemorySecretProvider() *MemorySecretProvider {
+	return &MemorySecretProvider{secrets: make(map[string][]Secret)}
+}
+
+// SetDefault sets the secrets used when no webhook, repository or project secrets match a request
+func (p *MemorySecretProvider) SetDefault(secrets ...Secret) {
+	p.set("", secrets)
+}
+
+// SetProject sets the secrets used for every repository of a project
+func (p *MemorySecretProvider) SetProject(projectKey string, secrets ...Secret) {
+	p.set(projectKey, secrets)
+}
+
+// SetRepository sets the secrets used for a repository
+func (p *MemorySecretProvider) SetRepository(projectKey, repositorySlug string, secrets ...Secret) {
+	p.set(projectKey+"/"+repositorySlug, secrets)
+}
+
+// SetWebhook sets the secrets used for a webhook ID. They are only used for events that do not belong to a project,
+// as the webhook ID is read from a request header that anyone can set.
+func (p *MemorySecretProvider) SetWebhook(webhookID string, secrets ...Secret) {
+	p.set("hook:"+webhookID, secrets)
+}
+
+func (p *MemorySecretProvider) set(key string, secrets []Secret) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.secrets[key] = secrets
+}
+
+// Secrets implements SecretProvider
+func (p *MemorySecretProvider) Secrets(ctx context.Context, query SecretQuery) ([]Secret, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var keys []string
+	if query.ProjectKey != "" && query.RepositorySlug != "" {
+		keys = append(keys, query.ProjectKey+"/"+query.RepositorySlug)
+	}
+	if query.ProjectKey != "" {
+		keys = append(keys, query.ProjectKey)
+	}
+	if query.ProjectKey == "" && query.WebhookID != "" {
+		keys = append(keys, "hook:"+query.WebhookID)
+	}
+	keys = append(keys, "")
+
+	for _, key := range keys {
+		if secrets, ok := p.secrets[key]; ok {
+			return secrets, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// EnvSecretProvider is a SecretProvider that reads secrets from environment variables. For a request from repository
+// "slug" in project "PROJ" and a Prefix of "BITBUCKET_SECRET", the following variables are tried in order:
+//
+//	BITBUCKET_SECRET_PROJ_SLUG
+//	BITBUCKET_SECRET_PROJ
+//	BITBUCKET_SECRET
+//
+// For events that do not belong to a project, BITBUCKET_SECRET_HOOK_<WEBHOOK_ID> is tried before BITBUCKET_SECRET.
+//
+// Names are upper-cased and any character other than a letter or digit is replaced with an underscore. The ID of
+// the returned secret is the name of the variable.
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+// Secrets implements SecretProvider
+func (p EnvSecretProvider) Secrets(ctx context.Context, query SecretQuery) ([]Secret, error) {
+	var names []string
+	if query.ProjectKey != "" && query.RepositorySlug != "" {
+		names = append(names, envName(p.Prefix, query.ProjectKey, query.RepositorySlug))
+	}
+	if query.ProjectKey != "" {
+		names = append(names, envName(p.Prefix, query.ProjectKey))
+	}
+	if query.ProjectKey == "" && query.WebhookID != "" {
+		names = append(names, envName(p.Prefix, "HOOK", query.WebhookID))
+	}
+	names = append(names, envName(p.Prefix))
+
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			return []Secret{{ID: name, Value: value}}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func envName(parts ...string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if i > 0 && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		for _, r := range strings.ToUpper(part) {
+			if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// DirSecretProvider is a SecretProvider that reads secrets from files in a directory. Files are re-read when they
+// change, so secrets can be rotated without restarting. For a request from repository "slug" in project "PROJ" the
+// following files are tried in order:
+//
+//	<dir>/PROJ/slug
+//	<dir>/PROJ/.default
+//	<dir>/.default
+//
+// For events that do not belong to a project, <dir>/.hooks/<webhook-id> is tried before <dir>/.default.
+//
+// Each non-empty line of a file that does not start with '#' is an accepted secret, which allows the old and new
+// secret to be listed while rotating. The ID of a secret is its file path relative to dir, followed by '#' and its
+// line number.
+type DirSecretProvider struct {
+	dir string
+
+	mu    sync.Mutex
+	cache map[string]cachedSecretFile
+}
+
+type cachedSecretFile struct {
+	modTime time.Time
+	size    int64
+	secrets []Secret
+}
+
+// NewDirSecretProvider creates a DirSecretProvider that reads secrets from dir
+func NewDirSecretProvider(dir string) *DirSecretProvider {
+	return &DirSecretProvider{
+		dir:   dir,
+		cache: make(map[string]cachedSecretFile),
+	}
+}
+
+// Secrets implements SecretProvider
+func (p *DirSecretProvider) Secrets(ctx context.Context, query SecretQuery) ([]Secret, error) {
+	var paths []string
+	if validPathName(query.ProjectKey) && validPathName(query.RepositorySlug) {
+		paths = append(paths, filepath.Join(query.ProjectKey, query.RepositorySlug))
+	}
+	if validPathName(query.ProjectKey) {
+		paths = append(paths, filepath.Join(query.ProjectKey, ".default"))
+	}
+	if query.ProjectKey == "" && validPathName(query.WebhookID) {
+		paths = append(paths, filepath.Join(".hooks", query.WebhookID))
+	}
+	paths = append(paths, ".default")
+
+	for _, path := range paths {
+		secrets, err := p.load(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(secrets) > 0 {
+			return secrets, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// load returns the secrets in the file at path, re-reading it only when its modification time or size changed
+func (p *DirSecretProvider) load(path string) ([]Secret, error) {
+	info, err := os.Stat(filepath.Join(p.dir, path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret file: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cached, ok := p.cache[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.secrets, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(p.dir, path))
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret file: %w", err)
+	}
+
+	var secrets []Secret
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		secrets = append(secrets, Secret{ID: fmt.Sprintf("%s#%d", filepath.ToSlash(path), line), Value: value})
+	}
+
+	p.cache[path] = cachedSecretFile{modTime: info.ModTime(), size: info.Size(), secrets: secrets}
+	return secrets, nil
+}
+
+// validPathName reports whether name, which comes from an unverified payload, is safe to use as a file name
+func validPathName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,245 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSecretQuery(t *testing.T) {
+	tc := []struct {
+		Fixture  string
+		EventKey EventKey
+		Expected SecretQuery
+	}{
+		{Fixture: "testdata/pr_opened.json", EventKey: EventPullRequestOpened, Expected: SecretQuery{ProjectKey: "PROJ", RepositorySlug: "repository"}},
+		{Fixture: "testdata/pr_comment_added.json", EventKey: EventPullRequestCommentAdded, Expected: SecretQuery{ProjectKey: "SEC", RepositorySlug: "payments"}},
+		{Fixture: "testdata/pr_from_ref_updated.json", EventKey: EventPullRequestFromRefUpdated, Expected: SecretQuery{ProjectKey: "PROJECT_1", RepositorySlug: "rep_1"}},
+		{Fixture: "testdata/repo_secret_detected.json", EventKey: EventRepoSecretDetected, Expected: SecretQuery{ProjectKey: "SEC", RepositorySlug: "payments"}},
+		{Fixture: "testdata/project_modified.json", EventKey: EventProjectModified, Expected: SecretQuery{ProjectKey: "PRJ"}},
+		{Fixture: "testdata/mirror_repo_synchronized.json", EventKey: EventMirrorRepoSynchronized, Expected: SecretQuery{ProjectKey: "PROJ", RepositorySlug: "repository"}},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Fixture)
+		body, err := ioutil.ReadFile(tt.Fixture)
+		if err != nil {
+			t.Fatalf("could not read fixture: %v", err)
+		}
+
+		header := http.Header{}
+		header.Set("X-Event-Key", string(tt.EventKey))
+		header.Set("X-Hook-UUID", "hook-1")
+		tt.Expected.EventKey = tt.EventKey
+
+		query, err := newSecretQuery(header, body)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Fixture, err)
+		}
+		if query != tt.Expected {
+			t.Errorf("%s: Expected: %+v, Got: %+v", tt.Fixture, tt.Expected, query)
+		}
+	}
+
+	fmt.Println("Test: webhook ID of an event without a project")
+	header := http.Header{}
+	header.Set("X-Event-Key", string(EventDiagnosticsPing))
+	header.Set("X-Hook-UUID", "hook-1")
+	expected := SecretQuery{EventKey: EventDiagnosticsPing, WebhookID: "hook-1"}
+	if query, err := newSecretQuery(header, nil); err != nil || query != expected {
+		t.Errorf("Expected: %+v, Got: %+v, %v", expected, query, err)
+	}
+}
+
+func TestSecretProviderForgedProject(t *testing.T) {
+	p := NewMemorySecretProvider()
+	p.SetProject("A", Secret{ID: "a", Value: "secret A"})
+	p.SetProject("B", Secret{ID: "b", Value: "secret B"})
+	p.SetWebhook("hook-a", Secret{ID: "hook-a", Value: "secret of hook A"})
+
+	toB := `"pullRequest": {"id": 1, "toRef": {"repository": {"slug": "repo", "project": {"key": "B"}}}}`
+
+	tc := []struct {
+		Name           string
+		Body           string
+		Secret         string
+		WebhookID      string
+		ExpectedErr    error
+		ExpectedStatus int
+	}{
+		{Name: "signed with the secret of the project", Body: `{"eventKey": "pr:opened", ` + toB + `}`, Secret: "secret B"},
+		{Name: "signed with the secret of another project", Body: `{"eventKey": "pr:opened", ` + toB + `}`, Secret: "secret A", ExpectedErr: ErrInvalidSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "repository of another project added to the payload", Body: `{"eventKey": "pr:opened", "repository": {"project": {"key": "A"}}, ` + toB + `}`, Secret: "secret A", ExpectedErr: ErrInvalidPayload, ExpectedStatus: http.StatusBadRequest},
+		{Name: "webhook ID of another project", Body: `{"eventKey": "pr:opened", ` + toB + `}`, Secret: "secret of hook A", WebhookID: "hook-a", ExpectedErr: ErrInvalidSignature, ExpectedStatus: http.StatusUnauthorized},
+		{Name: "project of another repository added to the payload", Body: `{"eventKey": "pr:opened", "new": {"key": "A"}, ` + toB + `}`, Secret: "secret A", ExpectedErr: ErrInvalidPayload, ExpectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.Body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		req.Header.Set("X-Hub-Signature", signature(tt.Secret, tt.Body))
+		if tt.WebhookID != "" {
+			req.Header.Set("X-Hook-UUID", tt.WebhookID)
+		}
+
+		event, err := New(WithSecretProvider(p)).Parse(req)
+		if tt.ExpectedErr == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			} else if key := event.(ProjectEvent).EventProject().Key; key != "B" {
+				t.Errorf("%s: Expected: %s, Got: %s", tt.Name, "B", key)
+			}
+			continue
+		}
+
+		if !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && parseErr.Status != tt.ExpectedStatus {
+			t.Errorf("%s: Expected: %d, Got: %d", tt.Name, tt.ExpectedStatus, parseErr.Status)
+		}
+	}
+}
+
+func TestMemorySecretProvider(t *testing.T) {
+	p := NewMemorySecretProvider()
+	p.SetDefault(Secret{ID: "default", Value: "d"})
+	p.SetProject("PROJ", Secret{ID: "project", Value: "p"})
+	p.SetRepository("PROJ", "repo", Secret{ID: "repo", Value: "r"})
+	p.SetWebhook("hook-1", Secret{ID: "hook", Value: "h"})
+
+	tc := []struct {
+		Query      SecretQuery
+		ExpectedID string
+	}{
+		{Query: SecretQuery{WebhookID: "hook-1"}, ExpectedID: "hook"},
+		{Query: SecretQuery{ProjectKey: "PROJ", RepositorySlug: "repo", WebhookID: "hook-1"}, ExpectedID: "repo"},
+		{Query: SecretQuery{ProjectKey: "OTHER", WebhookID: "hook-1"}, ExpectedID: "default"},
+		{Query: SecretQuery{ProjectKey: "PROJ", RepositorySlug: "repo"}, ExpectedID: "repo"},
+		{Query: SecretQuery{ProjectKey: "PROJ", RepositorySlug: "other"}, ExpectedID: "project"},
+		{Query: SecretQuery{ProjectKey: "OTHER", RepositorySlug: "repo"}, ExpectedID: "default"},
+	}
+
+	for _, tt := range tc {
+		secrets, err := p.Secrets(context.Background(), tt.Query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(secrets) != 1 || secrets[0].ID != tt.ExpectedID {
+			t.Errorf("%+v: Expected: %s, Got: %+v", tt.Query, tt.ExpectedID, secrets)
+		}
+	}
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("BB_SECRET_PROJ_MY_REPO", "repo secret")
+	t.Setenv("BB_SECRET", "default secret")
+
+	p := EnvSecretProvider{Prefix: "BB_SECRET"}
+
+	secrets, _ := p.Secrets(context.Background(), SecretQuery{ProjectKey: "PROJ", RepositorySlug: "my-repo"})
+	if len(secrets) != 1 || secrets[0].Value != "repo secret" || secrets[0].ID != "BB_SECRET_PROJ_MY_REPO" {
+		t.Errorf("Expected the repository secret, Got: %+v", secrets)
+	}
+
+	secrets, _ = p.Secrets(context.Background(), SecretQuery{ProjectKey: "OTHER", RepositorySlug: "repo"})
+	if len(secrets) != 1 || secrets[0].Value != "default secret" {
+		t.Errorf("Expected the default secret, Got: %+v", secrets)
+	}
+}
+
+func TestDirSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "PROJ"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	repoFile := filepath.Join(dir, "PROJ", "repo")
+	writeFile(t, repoFile, "# rotated 2024-06\nold secret\n\nnew secret\n")
+	writeFile(t, filepath.Join(dir, ".default"), "default secret\n")
+
+	p := NewDirSecretProvider(dir)
+	query := SecretQuery{ProjectKey: "PROJ", RepositorySlug: "repo"}
+
+	secrets, err := p.Secrets(context.Background(), query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0].Value != "old secret" || secrets[1].ID != "PROJ/repo#4" {
+		t.Errorf("Expected two secrets from PROJ/repo, Got: %+v", secrets)
+	}
+
+	// Rewrite the file and move its modification time forward so the change is noticed
+	writeFile(t, repoFile, "newest secret\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(repoFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, _ = p.Secrets(context.Background(), query)
+	if len(secrets) != 1 || secrets[0].Value != "newest secret" {
+		t.Errorf("Expected the file to be reloaded, Got: %+v", secrets)
+	}
+
+	secrets, _ = p.Secrets(context.Background(), SecretQuery{ProjectKey: "..", RepositorySlug: "PROJ/repo"})
+	if len(secrets) != 1 || secrets[0].Value != "default secret" {
+		t.Errorf("Expected unsafe names to fall back to the default secret, Got: %+v", secrets)
+	}
+}
+
+func TestParseWithSecretProvider(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/pr_opened.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %v", err)
+	}
+
+	p := NewMemorySecretProvider()
+	p.SetRepository("PROJ", "repository", Secret{ID: "repository", Value: "repo secret"})
+	hook := New(WithSecretProvider(p))
+
+	tc := []struct {
+		Name        string
+		Secret      string
+		ExpectedErr bool
+	}{
+		{Name: "repository secret", Secret: "repo secret"},
+		{Name: "wrong secret", Secret: "other secret", ExpectedErr: true},
+		{Name: "unsigned", ExpectedErr: true},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		if tt.Secret != "" {
+			req.Header.Set("X-Hub-Signature", signature(tt.Secret, string(body)))
+		}
+
+		d, err := hook.ParseDelivery(req)
+		if tt.ExpectedErr && err == nil || !tt.ExpectedErr && err != nil {
+			t.Errorf("%s: Expected error: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+			continue
+		}
+
+		if err == nil && d.SecretID != "repository" {
+			t.Errorf("%s: Expected: repository, Got: %s", tt.Name, d.SecretID)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
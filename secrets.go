@@ -0,0 +1,78 @@
+package bitbucket
+
+import "time"
+
+// defaultSecretID is the ID of the secret set by WithSecret
+const defaultSecretID = "default"
+
+// Secret is a webhook secret that is accepted when validating HMAC signatures. NotBefore and NotAfter can be used to
+// limit the time a secret is accepted, for example while rolling a new secret out to every repository.
+type Secret struct {
+	// ID identifies the secret without revealing its value. It is reported in Delivery.SecretID when the secret
+	// validates a request.
+	ID string
+
+	// Value is the secret configured in Bitbucket
+	Value string
+
+	// NotBefore is the time the secret starts being accepted. The zero time means the secret is accepted immediately.
+	NotBefore time.Time
+
+	// NotAfter is the time the secret stops being accepted. The zero time means the secret does not expire.
+	NotAfter time.Time
+}
+
+// ActiveAt returns true when the secret is accepted at time t
+func (s Secret) ActiveAt(t time.Time) bool {
+	if s.Value == "" {
+		return false
+	}
+
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+
+	if !s.NotAfter.IsZero() && t.After(s.NotAfter) {
+		return false
+	}
+
+	return true
+}
+
+// WithSecrets sets the secrets that are accepted when validating a Bitbucket HMAC signature. A request is valid when
+// its signature matches any secret that is active at the time it is received. It can be combined with WithSecret, in
+// which case the secret set by WithSecret has the ID "default".
+//
+// Example: rotate a secret during June 2024
+//
+//	webhook.New(WithSecrets(
+//		Secret{ID: "2024-01", Value: "OLD_SECRET", NotAfter: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+//		Secret{ID: "2024-06", Value: "NEW_SECRET", NotBefore: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+//	))
+func WithSecrets(secrets ...Secret) Option {
+	return func(w *Webhook) {
+		w.secrets = append(w.secrets, secrets...)
+	}
+}
+
+// configuredSecrets returns the secrets set by WithSecret and WithSecrets
+func (hook *Webhook) configuredSecrets() []Secret {
+	secrets := make([]Secret, 0, len(hook.secrets)+1)
+	if hook.secret != "" {
+		secrets = append(secrets, Secret{ID: defaultSecretID, Value: hook.secret})
+	}
+
+	return append(secrets, hook.secrets...)
+}
+
+// activeSecrets returns the secrets that are accepted at time t
+func activeSecrets(secrets []Secret, t time.Time) []Secret {
+	active := make([]Secret, 0, len(secrets))
+	for _, s := range secrets {
+		if s.ActiveAt(t) {
+			active = append(active, s)
+		}
+	}
+
+	return active
+}
@@ -0,0 +1,92 @@
+package bitbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretRotation(t *testing.T) {
+	const body = `{"eventKey": "pr:opened"}`
+
+	now := time.Now()
+	hook := New(
+		WithSecret("legacy"),
+		WithSecrets(
+			Secret{ID: "old", Value: "old secret", NotAfter: now.Add(time.Hour)},
+			Secret{ID: "new", Value: "new secret", NotBefore: now.Add(-time.Hour)},
+			Secret{ID: "expired", Value: "expired secret", NotAfter: now.Add(-time.Minute)},
+			Secret{ID: "future", Value: "future secret", NotBefore: now.Add(time.Hour)},
+		),
+	)
+
+	tc := []struct {
+		Name        string
+		Secret      string
+		ExpectedID  string
+		ExpectedErr bool
+	}{
+		{Name: "secret set by WithSecret", Secret: "legacy", ExpectedID: "default"},
+		{Name: "old secret", Secret: "old secret", ExpectedID: "old"},
+		{Name: "new secret", Secret: "new secret", ExpectedID: "new"},
+		{Name: "expired secret", Secret: "expired secret", ExpectedErr: true},
+		{Name: "secret not active yet", Secret: "future secret", ExpectedErr: true},
+		{Name: "unknown secret", Secret: "unknown", ExpectedErr: true},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		req.Header.Set("X-Hub-Signature", signature(tt.Secret, body))
+
+		d, err := hook.ParseDelivery(req)
+		if tt.ExpectedErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error", tt.Name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+
+		if d.SecretID != tt.ExpectedID {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.ExpectedID, d.SecretID)
+		}
+	}
+}
+
+func TestVerifySignatureWithSecrets(t *testing.T) {
+	payload := []byte(`{"eventKey": "pr:opened"}`)
+	hook := New()
+
+	secret, err := hook.VerifySignatureWithSecrets(payload, signature("b", string(payload)), []Secret{
+		{ID: "a", Value: "a"},
+		{ID: "b", Value: "b"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.ID != "b" {
+		t.Errorf("Expected: b, Got: %s", secret.ID)
+	}
+
+	if _, err := hook.VerifySignatureWithSecrets(payload, signature("b", string(payload)), nil); err == nil {
+		t.Errorf("Expected an error when no secrets are set")
+	}
+}
+
+func TestSecretsRequireSignature(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"eventKey": "pr:opened"}`))
+	req.Header.Set("X-Event-Key", "pr:opened")
+
+	if _, err := New(WithSecrets(Secret{ID: "a", Value: "a"})).Parse(req); err == nil {
+		t.Errorf("Expected unsigned requests to be rejected when secrets are set")
+	}
+}
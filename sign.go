@@ -0,0 +1,92 @@
+package bitbucket
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Sign returns the X-Hub-Signature header value for payload, signed with secret using sha256. This is the format
+// Bitbucket uses and VerifySignature expects, e.g. "sha256=f7bc83f4...".
+func Sign(payload []byte, secret string) string {
+	signature, _ := SignWithAlgorithm(AlgorithmSHA256, payload, secret)
+	return signature
+}
+
+// SignWithAlgorithm returns the X-Hub-Signature header value for payload, signed with secret using a registered
+// signature algorithm
+func SignWithAlgorithm(algorithm string, payload []byte, secret string) (string, error) {
+	hashFn, ok := signatureAlgorithm(algorithm)
+	if !ok {
+		return "", fmt.Errorf("signature algorithm '%s' is not registered", algorithm)
+	}
+
+	mac := hmac.New(hashFn, []byte(secret))
+	if _, err := mac.Write(payload); err != nil {
+		return "", fmt.Errorf("failed to write message as a MAC: %w", err)
+	}
+
+	return algorithm + "=" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// SigningTransport is an http.RoundTripper that signs the body of outgoing requests and sets the X-Hub-Signature
+// header, so relays and test tools can forward or create Bitbucket compatible deliveries.
+//
+// Example:
+//
+//	client := &http.Client{Transport: &webhook.SigningTransport{Secret: "WEBHOOK_SECRET"}}
+//	req, _ := http.NewRequest(http.MethodPost, "https://ci.example.com/bitbucket", bytes.NewReader(payload))
+//	req.Header.Set("X-Event-Key", "pr:opened")
+//	resp, err := client.Do(req)
+type SigningTransport struct {
+	// Secret is the key used to sign requests
+	Secret string
+
+	// Algorithm is the registered signature algorithm used to sign requests. It defaults to sha256.
+	Algorithm string
+
+	// Base is the RoundTripper used to send signed requests. It defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload []byte
+	if req.Body != nil {
+		var err error
+		payload, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read request body: %w", err)
+		}
+	}
+
+	algorithm := t.Algorithm
+	if algorithm == "" {
+		algorithm = AlgorithmSHA256
+	}
+
+	signature, err := SignWithAlgorithm(algorithm, payload, t.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := req.Clone(req.Context())
+	signed.Header.Set("X-Hub-Signature", signature)
+	signed.ContentLength = int64(len(payload))
+	signed.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	signed.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(payload)), nil
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(signed)
+}
@@ -0,0 +1,63 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"eventKey": "pr:opened"}`)
+
+	if got, expected := Sign(payload, "secret"), signature("secret", string(payload)); got != expected {
+		t.Errorf("Expected: %s, Got: %s", expected, got)
+	}
+
+	if err := New().VerifySignature(payload, Sign(payload, "secret"), "secret"); err != nil {
+		t.Errorf("Expected the signature to be valid, Got: %v", err)
+	}
+
+	if _, err := SignWithAlgorithm("sha3", payload, "secret"); err == nil {
+		t.Errorf("Expected an error for an unregistered algorithm")
+	}
+}
+
+func TestSigningTransport(t *testing.T) {
+	const body = `{"eventKey": "pr:opened"}`
+
+	var opened bool
+	hook := New(WithSecret("secret"), WithSignatureAlgorithms(AlgorithmSHA512))
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		opened = true
+		return nil
+	})
+
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	client := &http.Client{Transport: &SigningTransport{Secret: "secret", Algorithm: AlgorithmSHA512}}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Event-Key", "pr:opened")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected: %d, Got: %d", http.StatusOK, resp.StatusCode)
+	}
+	if !opened {
+		t.Errorf("Expected the pr:opened handler to be called")
+	}
+	if req.Header.Get("X-Hub-Signature") != "" {
+		t.Errorf("Expected the original request not to be modified")
+	}
+}
@@ -0,0 +1,317 @@
+package bitbucket
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SpoolEntry is a delivery stored in a Spool
+type SpoolEntry struct {
+	// Seq is the sequence number of the entry, which is used to acknowledge it
+	Seq uint64 `json:"seq"`
+
+	// EventKey is the value of the X-Event-Key header
+	EventKey EventKey `json:"eventKey,omitempty"`
+
+	// RequestID is the value of the X-Request-Id header
+	RequestID string `json:"requestId,omitempty"`
+
+	// Header holds the headers of the request, with credentials redacted
+	Header http.Header `json:"header,omitempty"`
+
+	// Payload is the request body as held in Delivery.Raw, decompressed when WithGzip is set
+	Payload []byte `json:"payload,omitempty"`
+
+	// ReceivedAt is the time the request was received
+	ReceivedAt time.Time `json:"receivedAt,omitempty"`
+}
+
+// spoolRecord is a line of the spool log. It either appends an entry or acknowledges the entry with the same Seq.
+type spoolRecord struct {
+	SpoolEntry
+	Ack bool `json:"ack,omitempty"`
+}
+
+// Spool is a write-ahead log of verified deliveries. A delivery is appended and synced to disk before it is
+// acknowledged to Bitbucket, and acknowledged in the spool once its handlers are done, so that deliveries that were
+// accepted but not handled when the process stopped can be handled after a restart. It is safe for concurrent use.
+type Spool struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	next    uint64
+	pending map[uint64]SpoolEntry
+	corrupt int
+
+	// records is the number of records in the log, and compactAt the number of records from which the log is
+	// compacted once most of them belong to acknowledged entries
+	records   int
+	compactAt int
+}
+
+// spoolCompactRecords is the number of records a spool log must hold before it is compacted while in use
+const spoolCompactRecords = 1000
+
+// OpenSpool opens or creates the spool log at path and loads the entries that were not acknowledged. The log is
+// rewritten to only hold those entries.
+func OpenSpool(path string) (*Spool, error) {
+	s := &Spool{
+		path:      path,
+		next:      1,
+		pending:   make(map[uint64]SpoolEntry),
+		compactAt: spoolCompactRecords,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the records of the log. A record that was only partly written when the process stopped is ignored, and
+// a record that cannot be read is skipped and counted in corrupt.
+func (s *Spool) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open spool: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			s.apply(line)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// apply applies a line of the log to the pending entries
+func (s *Spool) apply(line []byte) {
+	var record spoolRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		s.corrupt++
+		return
+	}
+
+	if record.Ack {
+		delete(s.pending, record.Seq)
+	} else {
+		s.pending[record.Seq] = record.SpoolEntry
+	}
+	if record.Seq >= s.next {
+		s.next = record.Seq + 1
+	}
+}
+
+// compact replaces the log with one that only holds the pending entries
+func (s *Spool) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not compact spool: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, entry := range s.sortedPending() {
+		if err := writeSpoolRecord(w, spoolRecord{SpoolEntry: entry}); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("could not compact spool: %w", err)
+		}
+	}
+
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact spool: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact spool: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not compact spool: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open spool: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.records = len(s.pending)
+
+	return nil
+}
+
+// Corrupt returns the number of records that could not be read when the spool was opened. Those records were dropped
+// from the log.
+func (s *Spool) Corrupt() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.corrupt
+}
+
+func writeSpoolRecord(w io.Writer, record spoolRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Append stores a delivery in the spool and syncs it to disk. The returned sequence number is used to acknowledge it.
+func (s *Spool) Append(d *Delivery) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := SpoolEntry{
+		Seq:        s.next,
+		EventKey:   d.EventKey,
+		RequestID:  d.RequestID,
+		Payload:    d.Raw,
+		ReceivedAt: d.ReceivedAt,
+	}
+	if d.Header != nil {
+		entry.Header = redactHeader(d.Header)
+	}
+
+	if err := s.write(spoolRecord{SpoolEntry: entry}, true); err != nil {
+		return 0, err
+	}
+
+	s.next++
+	s.pending[entry.Seq] = entry
+
+	return entry.Seq, nil
+}
+
+// Ack marks an entry as handled. Acknowledgements are not synced to disk, so an entry may be handled again after a
+// crash. The log is truncated once every entry has been acknowledged, and rewritten with only the pending entries once
+// most of its records belong to acknowledged entries, so it does not grow while some entry is always pending.
+func (s *Spool) Ack(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pending[seq]; !ok {
+		return nil
+	}
+	delete(s.pending, seq)
+
+	if len(s.pending) == 0 {
+		if err := s.file.Truncate(0); err != nil {
+			return fmt.Errorf("could not compact spool: %w", err)
+		}
+		s.records = 0
+		return nil
+	}
+
+	// The rewritten log does not hold the entry, so the acknowledgement does not need to be written
+	if s.records >= s.compactAt && s.records > 2*len(s.pending) {
+		return s.compact()
+	}
+
+	return s.write(spoolRecord{SpoolEntry: SpoolEntry{Seq: seq}, Ack: true}, false)
+}
+
+// write appends a record to the log, syncing it to disk if sync is set. When the write fails the log is truncated to
+// its previous size, so that a partly written record does not corrupt the records appended after it.
+func (s *Spool) write(record spoolRecord, sync bool) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("could not write to spool: %w", err)
+	}
+
+	err = writeSpoolRecord(s.file, record)
+	if err == nil && sync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		if truncErr := s.file.Truncate(info.Size()); truncErr != nil {
+			return fmt.Errorf("could not write to spool: %v; could not truncate spool: %w", err, truncErr)
+		}
+		return fmt.Errorf("could not write to spool: %w", err)
+	}
+	s.records++
+
+	return nil
+}
+
+// Pending returns the entries that have not been acknowledged, oldest first
+func (s *Spool) Pending() []SpoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedPending()
+}
+
+func (s *Spool) sortedPending() []SpoolEntry {
+	entries := make([]SpoolEntry, 0, len(s.pending))
+	for _, entry := range s.pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+
+	return entries
+}
+
+// Close closes the log of the spool
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// delivery rebuilds the delivery of an entry. The payload was verified when it was appended, so it is decoded
+// without verifying its signature again.
+func (e SpoolEntry) delivery() (*Delivery, error) {
+	event, err := decodeStoredEvent(e.EventKey, e.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Delivery{
+		Event:      event,
+		Raw:        e.Payload,
+		EventKey:   e.EventKey,
+		RequestID:  e.RequestID,
+		UserAgent:  e.Header.Get("User-Agent"),
+		Header:     e.Header,
+		ReceivedAt: e.ReceivedAt,
+	}, nil
+}
@@ -0,0 +1,245 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.log")
+
+	s, err := OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Hub-Signature", "sha256=abcd")
+
+	var seqs []uint64
+	for i := 1; i <= 3; i++ {
+		seq, err := s.Append(&Delivery{EventKey: EventPullRequestOpened, RequestID: fmt.Sprint(i), Raw: []byte(`{"eventKey": "pr:opened"}`), Header: header})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if err := s.Ack(seqs[1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Simulate a crash while a record was being written
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.WriteString(`{"seq":4,"eventKey":"pr:op`)
+	f.Close()
+
+	s, err = OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	fmt.Println("Test: pending entries after reopening")
+	pending := s.Pending()
+	if len(pending) != 2 || pending[0].RequestID != "1" || pending[1].RequestID != "3" {
+		t.Fatalf("Expected entries 1 and 3 to be pending, Got: %+v", pending)
+	}
+	if pending[0].Header.Get("X-Hub-Signature") != "REDACTED" {
+		t.Errorf("Expected the signature to be redacted, Got: %s", pending[0].Header.Get("X-Hub-Signature"))
+	}
+	if d, err := pending[0].delivery(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, ok := d.Event.(PullRequestOpenedPayload); !ok {
+		t.Errorf("Expected: %T, Got: %T", PullRequestOpenedPayload{}, d.Event)
+	}
+
+	fmt.Println("Test: sequence numbers continue after reopening")
+	seq, err := s.Append(&Delivery{EventKey: EventDiagnosticsPing})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seq != 4 {
+		t.Errorf("Expected: %d, Got: %d", 4, seq)
+	}
+
+	fmt.Println("Test: log is truncated once every entry is acknowledged")
+	for _, seq := range []uint64{pending[0].Seq, pending[1].Seq, seq} {
+		if err := s.Ack(seq); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty log, Got: %v, %v", info.Size(), err)
+	}
+}
+
+func TestSpoolCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.log")
+
+	s, err := OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.compactAt = 10
+
+	// An entry that is never acknowledged keeps the log from being truncated
+	if _, err := s.Append(&Delivery{EventKey: EventDiagnosticsPing, RequestID: "stuck"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		seq, err := s.Append(&Delivery{EventKey: EventDiagnosticsPing, RequestID: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Ack(seq); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	fmt.Println("Test: log is compacted while an entry is pending")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > s.compactAt {
+		t.Errorf("Expected at most %d records, Got: %d", s.compactAt, lines)
+	}
+
+	fmt.Println("Test: pending entry is kept after compaction")
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err = OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	pending := s.Pending()
+	if len(pending) != 1 || pending[0].RequestID != "stuck" {
+		t.Errorf("Expected the stuck entry to be pending, Got: %+v", pending)
+	}
+}
+
+func TestSpoolCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.log")
+
+	// A record that was partly written, followed by a record appended after it
+	log := `{"seq":1,"eventKey":"diagnostics:ping","requestId":"1"}` + "\n" +
+		`{"seq":2,"eventKey":"pr:op{"seq":3,"eventKey":"diagnostics:ping","requestId":"3"}` + "\n" +
+		`{"seq":4,"eventKey":"diagnostics:ping","requestId":"4"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err := OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fmt.Println("Test: corrupt records are skipped")
+	pending := s.Pending()
+	if len(pending) != 2 || pending[0].RequestID != "1" || pending[1].RequestID != "4" {
+		t.Fatalf("Expected entries 1 and 4 to be pending, Got: %+v", pending)
+	}
+	if s.Corrupt() != 1 {
+		t.Errorf("Expected: %d, Got: %d", 1, s.Corrupt())
+	}
+
+	fmt.Println("Test: corrupt records are dropped from the log")
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err = OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+	if s.Corrupt() != 0 {
+		t.Errorf("Expected: %d, Got: %d", 0, s.Corrupt())
+	}
+}
+
+func TestDispatcherSpoolRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.log")
+
+	spool, err := OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first process accepts two deliveries, but is stopped before their handlers finish
+	started := make(chan struct{}, 2)
+	hook := New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	d := NewDispatcher(hook, WithWorkers(2), WithSpool(spool))
+	for i := 1; i <= 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"eventKey": "pr:opened", "pullRequest": {"id": %d}}`, i)))
+		req.Header.Set("X-Event-Key", "pr:opened")
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected: %d, Got: %d", http.StatusAccepted, rec.Code)
+		}
+	}
+	<-started
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	d.Shutdown(ctx)
+	d.wg.Wait()
+	spool.Close()
+
+	// The second process handles the deliveries left in the spool
+	spool, err = OpenSpool(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	fmt.Println("Test: deliveries recovered from the spool")
+	var (
+		mu      sync.Mutex
+		handled []uint64
+	)
+	hook = New()
+	hook.OnPullRequestOpened(func(ctx context.Context, pl PullRequestOpenedPayload) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, pl.PullRequest.ID)
+		return nil
+	})
+
+	d = NewDispatcher(hook, WithWorkers(1), WithSpool(spool))
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fmt.Sprint(handled) != "[1 2]" {
+		t.Errorf("Expected: [1 2], Got: %v", handled)
+	}
+	if pending := spool.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending entries, Got: %d", len(pending))
+	}
+}
@@ -0,0 +1,94 @@
+package bitbucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// bitbucketDateLayout is the layout of the "date" field of Bitbucket Webhook events, e.g. "2017-09-19T09:58:11+1000"
+const bitbucketDateLayout = "2006-01-02T15:04:05-0700"
+
+type timestampFormat int
+
+const (
+	formatEpochMillis timestampFormat = iota
+	formatBitbucket
+	formatRFC3339
+)
+
+// Timestamp is a point in time sent by Bitbucket. Bitbucket uses epoch milliseconds for fields such as createdDate
+// and an ISO 8601 string for the date of an event; Timestamp unmarshals both into a time.Time and remembers the
+// format so it marshals back to the same JSON it was decoded from.
+//
+// Because Timestamp embeds time.Time, durations can be computed directly:
+//
+//	timeToMerge := pr.ClosedDate.Sub(pr.CreatedDate.Time)
+type Timestamp struct {
+	time.Time
+	format timestampFormat
+}
+
+// NewTimestamp returns a Timestamp for t that marshals as epoch milliseconds
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t, format: formatEpochMillis}
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts epoch milliseconds, Bitbucket's event date format and RFC 3339.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("invalid timestamp: %w", err)
+		}
+
+		if s == "" {
+			*t = Timestamp{}
+			return nil
+		}
+
+		if parsed, err := time.Parse(bitbucketDateLayout, s); err == nil {
+			*t = Timestamp{Time: parsed, format: formatBitbucket}
+			return nil
+		}
+
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp '%s': %w", s, err)
+		}
+
+		*t = Timestamp{Time: parsed, format: formatRFC3339}
+		return nil
+	}
+
+	ms, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp '%s': %w", data, err)
+	}
+
+	*t = Timestamp{Time: time.Unix(0, ms*int64(time.Millisecond)), format: formatEpochMillis}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The zero Timestamp is marshalled as null.
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+
+	switch t.format {
+	case formatBitbucket:
+		return json.Marshal(t.Format(bitbucketDateLayout))
+	case formatRFC3339:
+		return json.Marshal(t.Format(time.RFC3339Nano))
+	default:
+		return []byte(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)), nil
+	}
+}
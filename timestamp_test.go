@@ -0,0 +1,81 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimestampRoundTrip(t *testing.T) {
+	tc := []struct {
+		Name     string
+		JSON     string
+		Expected time.Time
+	}{
+		{
+			Name:     "epoch milliseconds",
+			JSON:     `1505786146212`,
+			Expected: time.Unix(1505786146, 212*int64(time.Millisecond)),
+		},
+		{
+			Name:     "bitbucket event date",
+			JSON:     `"2017-09-19T09:58:11+1000"`,
+			Expected: time.Date(2017, time.September, 18, 23, 58, 11, 0, time.UTC),
+		},
+		{
+			Name:     "RFC 3339",
+			JSON:     `"2017-09-19T09:58:11+10:00"`,
+			Expected: time.Date(2017, time.September, 18, 23, 58, 11, 0, time.UTC),
+		},
+		{
+			Name: "null",
+			JSON: `null`,
+		},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		var ts Timestamp
+		if err := json.Unmarshal([]byte(tt.JSON), &ts); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+
+		if !ts.Equal(tt.Expected) {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.Expected, ts.Time)
+		}
+
+		b, err := json.Marshal(ts)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+
+		if string(b) != tt.JSON {
+			t.Errorf("%s: Expected: %s, Got: %s", tt.Name, tt.JSON, b)
+		}
+	}
+}
+
+func TestTimestampInvalid(t *testing.T) {
+	for _, data := range []string{`"yesterday"`, `true`, `1.5e12x`} {
+		var ts Timestamp
+		if err := json.Unmarshal([]byte(data), &ts); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestTimeToMerge(t *testing.T) {
+	var pr PullRequest
+	err := json.Unmarshal([]byte(`{"createdDate": 1505786146212, "closedDate": 1505786290773}`), &pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := 144561 * time.Millisecond
+	if d := pr.ClosedDate.Sub(pr.CreatedDate.Time); d != expected {
+		t.Errorf("Expected: %s, Got: %s", expected, d)
+	}
+}
@@ -1,16 +1,25 @@
 package bitbucket
 
+import "time"
+
 // EventKey stores the key for an event received by Bitbucket
 type EventKey string
 
-// EventDate stores the date an event was trigger by Bitbucket
-type EventDate string
-
 type commonBitbucketEventFields struct {
 	// EventKey is the event key of a Bitbucket Webhook
 	EventKey string `json:"eventKey"`
 	// EventDate is the date the event occurred
-	EventDate string `json:"date"`
+	EventDate Timestamp `json:"date"`
+}
+
+// Key returns the event key of the event
+func (c commonBitbucketEventFields) Key() EventKey {
+	return EventKey(c.EventKey)
+}
+
+// Date returns the date the event occurred
+func (c commonBitbucketEventFields) Date() time.Time {
+	return c.EventDate.Time
 }
 
 // DiagnosticPingEvent maps to "diagnostic:ping" Bitbucekt webhook events
@@ -18,6 +27,16 @@ type DiagnosticPingEvent struct {
 	Test bool `json:"test"`
 }
 
+// Key returns the "diagnostics:ping" event key
+func (DiagnosticPingEvent) Key() EventKey {
+	return EventDiagnosticsPing
+}
+
+// Date returns the zero time, as Bitbucket does not send a date with "diagnostics:ping" events
+func (DiagnosticPingEvent) Date() time.Time {
+	return time.Time{}
+}
+
 // PullRequestOpenedPayload maps to "pr:opened" Bitbucket Webook events
 type PullRequestOpenedPayload struct {
 	commonBitbucketEventFields
@@ -62,7 +81,7 @@ type PullRequestReviewerPayload struct {
 	Actor          `json:"actor"`
 	PullRequest    `json:"pullRequest"`
 	Participant    `json:"participant"`
-	PreviousStatus string `json:"previousStatus"`
+	PreviousStatus ParticipantStatus `json:"previousStatus"`
 }
 
 // PullRequestReviewerUpdatedPayload maps to a "pr:reviewer:updated" Bitbucket event
@@ -128,22 +147,92 @@ type RepoModifiedPayload struct {
 	NewVersion RepoVersion `json:"new"`
 }
 
-// FromRefUpdatedPayload maps to 'from_ref_updated' Bitbucket Webhook events
+// EventRepository returns the repository after it was modified
+func (p RepoModifiedPayload) EventRepository() Repository {
+	v := p.NewVersion
+	return Repository{
+		Slug:          v.Slug,
+		ID:            uint64(v.ID),
+		Name:          v.Name,
+		ScmID:         v.ScmID,
+		State:         v.State,
+		StatusMessage: v.StatusMessage,
+		Forkable:      v.Forkable,
+		Project:       v.Project,
+		Public:        v.Public,
+	}
+}
+
+// EventProject returns the project of the repository after it was modified
+func (p RepoModifiedPayload) EventProject() Project {
+	return p.NewVersion.Project
+}
+
+// FromRefUpdatedPayload maps to 'pr:from_ref_updated' Bitbucket Webhook events
 type FromRefUpdatedPayload struct {
 	commonBitbucketEventFields
 	Actor            `json:"actor"`
 	PullRequest      `json:"pullRequest"`
-	PreviousFromHash string `json:"previousFramHash"`
+	PreviousFromHash string `json:"previousFromHash"`
+}
+
+// RepoSecretDetectedPayload maps to 'repo:secret_detected' Bitbucket Webhook events
+type RepoSecretDetectedPayload struct {
+	commonBitbucketEventFields
+
+	// Actor is the user that pushed the commit containing the secret
+	Actor `json:"actor"`
+
+	// Repository is the repository the secret was pushed to
+	Repository `json:"repository"`
+
+	// SecretFinding describes where the secret was found
+	SecretFinding `json:"secretFinding"`
+}
+
+// ProjectModifiedPayload maps to 'project:modified' Bitbucket Webhook events
+type ProjectModifiedPayload struct {
+	commonBitbucketEventFields
+	Actor      `json:"actor"`
+	OldVersion Project `json:"old"`
+	NewVersion Project `json:"new"`
+}
+
+// EventProject returns the project after it was modified
+func (p ProjectModifiedPayload) EventProject() Project {
+	return p.NewVersion
+}
+
+// MirrorRepoSynchronizedPayload maps to 'mirror:repo_synchronized' Bitbucket Webhook events
+type MirrorRepoSynchronizedPayload struct {
+	commonBitbucketEventFields
+
+	// MirrorServer is the smart mirror that synchronized the repository
+	MirrorServer `json:"mirrorServer"`
+
+	// SyncType is either "incremental" or "snapshot"
+	SyncType MirrorSyncType `json:"syncType"`
+
+	// RefLimitExceeded is true when too many refs changed for them all to be included in Changes
+	RefLimitExceeded bool `json:"refLimitExceeded"`
+
+	// Repository is the repository that was synchronized
+	Repository `json:"repository"`
+
+	// Changes are the ref changes applied to the mirror. It is empty when RefLimitExceeded is true.
+	Changes []Changes `json:"changes"`
 }
 
 // RepoForkPayload maps to `repo:forked` Bitbucket webhook events
 type RepoForkPayload struct {
+	commonBitbucketEventFields
 	Actor      `json:"actor"`
 	Repository `json:"repository"`
 }
 
 // RepoCommentAddedPayload maps to `repo:comment:added` Bitbucket webhook events
 type RepoCommentAddedPayload struct {
+	commonBitbucketEventFields
 	Actor      `json:"actor"`
 	Comment    `json:"comment"`
 	Repository `json:"repository"`
@@ -152,6 +241,7 @@ type RepoCommentAddedPayload struct {
 
 // RepoCommentEditedPayload maps to `repo:comment:edited` Bitbucket Webhook events
 type RepoCommentEditedPayload struct {
+	commonBitbucketEventFields
 	Actor           `json:"actor"`
 	Comment         `json:"comment"`
 	PreviousComment string `json:"previousComment"`
@@ -161,6 +251,7 @@ type RepoCommentEditedPayload struct {
 
 // RepoCommentDeletedPayload maps to `repo:comment:deleted` Bitbucket Webhook events
 type RepoCommentDeletedPayload struct {
+	commonBitbucketEventFields
 	Actor      `json:"actor"`
 	Comment    `json:"comment"`
 	Repository `json:"repository"`
@@ -178,102 +269,182 @@ type Actor struct {
 	Type         string `json:"type"`
 }
 
+// EventActor returns the user that triggered the event
+func (a Actor) EventActor() Actor {
+	return a
+}
+
 // PullRequest represents the pullRequest field of a Bitbucket Webhook request
 type PullRequest struct {
-	ID          uint64 `json:"id"`
-	Version     uint64 `json:"version"`
-	Title       string `json:"title"`
-	State       string `json:"state"`
-	Open        bool   `json:"open"`
-	Closed      bool   `json:"closed"`
-	CreatedDate uint64 `json:"createdDate"`
-	UpdatedDate uint64 `json:"updatedDate"`
-	FromRef     Ref    `json:"fromRef"`
-	ToRef       Ref    `json:"toRef"`
+	ID           uint64                   `json:"id"`
+	Version      uint64                   `json:"version"`
+	Title        string                   `json:"title"`
+	Description  string                   `json:"description"`
+	State        PullRequestState         `json:"state"`
+	Open         bool                     `json:"open"`
+	Closed       bool                     `json:"closed"`
+	Draft        bool                     `json:"draft"`
+	Locked       bool                     `json:"locked"`
+	CreatedDate  Timestamp                `json:"createdDate"`
+	UpdatedDate  Timestamp                `json:"updatedDate"`
+	ClosedDate   Timestamp                `json:"closedDate"`
+	FromRef      Ref                      `json:"fromRef"`
+	ToRef        Ref                      `json:"toRef"`
+	Author       PullRequestParticipant   `json:"author"`
+	Reviewers    []PullRequestParticipant `json:"reviewers"`
+	Participants []PullRequestParticipant `json:"participants"`
+	Properties   PullRequestProperties    `json:"properties"`
+	Links        Links                    `json:"links"`
+}
+
+// PullRequestParticipant maps to the author, reviewers and participants of a pull request
+type PullRequestParticipant struct {
+	Actor              `json:"user"`
+	LastReviewedCommit string            `json:"lastReviewedCommit,omitempty"`
+	Role               ParticipantRole   `json:"role"`
+	Approved           bool              `json:"approved"`
+	Status             ParticipantStatus `json:"status"`
+}
+
+// PullRequestProperties maps to the properties key of a pull request
+type PullRequestProperties struct {
+	MergeResult       *MergeResult `json:"mergeResult,omitempty"`
+	MergeCommit       *Commit      `json:"mergeCommit,omitempty"`
+	CommentCount      uint         `json:"commentCount"`
+	OpenTaskCount     uint         `json:"openTaskCount"`
+	ResolvedTaskCount uint         `json:"resolvedTaskCount"`
+}
+
+// MergeResult maps to the mergeResult property of a pull request
+type MergeResult struct {
+	// Outcome is one of "CLEAN", "CONFLICTED" or "UNKNOWN"
+	Outcome string `json:"outcome"`
+	// Current is false when the outcome was calculated for an older version of the pull request
+	Current bool `json:"current"`
+}
+
+// Commit maps to a commit reference from a Bitbucket event
+type Commit struct {
+	ID        string `json:"id"`
+	DisplayID string `json:"displayId"`
+}
+
+// Links maps to the links key from a Bitbucket event
+type Links struct {
+	Self []Link `json:"self"`
+}
+
+// Link maps to a single link from a Bitbucket event
+type Link struct {
+	Href string `json:"href"`
+	Name string `json:"name,omitempty"`
+}
+
+// EventRepository returns the repository the pull request targets
+func (pr PullRequest) EventRepository() Repository {
+	return pr.ToRef.Repository
+}
+
+// EventProject returns the project of the repository the pull request targets
+func (pr PullRequest) EventProject() Project {
+	return pr.ToRef.Repository.Project
 }
 
 // Ref represents the fromRef field of a Bitbucket Webhook request
 type Ref struct {
-	ID           string `json:"id"`
-	DisplayID    string `json:"displayId"`
-	LatestCommit string `json:"latestCommit"`
+	ID           string  `json:"id"`
+	DisplayID    string  `json:"displayId"`
+	Type         RefType `json:"type"`
+	LatestCommit string  `json:"latestCommit"`
 	Repository   `json:"repository"`
 }
 
 // Repository maps to the repository key from a Bitbucket event
 type Repository struct {
-	Slug          string `json:"slug"`
-	ID            uint64 `json:"id"`
-	Name          string `json:"name"`
-	ScmID         string `json:"scmId"`
-	State         string `json:"state"`
-	StatusMessage string `json:"statusMessage"`
-	Forkable      bool   `json:"forkable"`
+	Slug          string          `json:"slug"`
+	ID            uint64          `json:"id"`
+	Name          string          `json:"name"`
+	ScmID         string          `json:"scmId"`
+	State         RepositoryState `json:"state"`
+	StatusMessage string          `json:"statusMessage"`
+	Forkable      bool            `json:"forkable"`
 	Project       `json:"project"`
 	Public        bool `json:"public"`
 	Origin        struct {
-		Slug          string `json:"slug"`
-		ID            uint64 `json:"id"`
-		Name          string `json:"name"`
-		ScmID         string `json:"scmId"`
-		State         string `json:"state"`
-		StatusMessage string `json:"statusMessage"`
-		Forkable      bool   `json:"forkable"`
+		Slug          string          `json:"slug"`
+		ID            uint64          `json:"id"`
+		Name          string          `json:"name"`
+		ScmID         string          `json:"scmId"`
+		State         RepositoryState `json:"state"`
+		StatusMessage string          `json:"statusMessage"`
+		Forkable      bool            `json:"forkable"`
 		Project       `json:"project"`
 		Public        bool `json:"public"`
 	} `json:"origin,omitempty"`
 }
 
+// EventRepository returns the repository the event occurred in
+func (r Repository) EventRepository() Repository {
+	return r
+}
+
 // Project maps to the project key from a Bitbucket event
 type Project struct {
-	Key    string `json:"key"`
-	ID     uint64 `json:"id"`
-	Name   string `json:"name"`
-	Public bool   `json:"public"`
-	Type   string `json:"type"`
+	Key         string      `json:"key"`
+	ID          uint64      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Public      bool        `json:"public"`
+	Type        ProjectType `json:"type"`
+}
+
+// EventProject returns the project the event occurred in
+func (p Project) EventProject() Project {
+	return p
 }
 
 // Changes maps to the changes key from a Bitbucket event
 type Changes struct {
 	Ref struct {
-		ID        string `json:"id"`
-		DisplayID string `json:"displayId"`
-		Type      string `json:"type"`
+		ID        string  `json:"id"`
+		DisplayID string  `json:"displayId"`
+		Type      RefType `json:"type"`
 	} `json:"ref"`
-	RefID  string `json:"refId"`
-	ToHash string `json:"toHash"`
-	Type   string `json:"type"`
+	RefID    string        `json:"refId"`
+	FromHash string        `json:"fromHash"`
+	ToHash   string        `json:"toHash"`
+	Type     RefChangeType `json:"type"`
+}
+
+// MirrorServer maps to the mirrorServer key from a Bitbucket event
+type MirrorServer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 // RepoVersion maps to the version key of a Bitbucket event
 type RepoVersion struct {
-	Slug          string `json:"slug"`
-	ID            int    `json:"id"`
-	Name          string `json:"name"`
-	ScmID         string `json:"scmId"`
-	State         string `json:"state"`
-	StatusMessage string `json:"statusMessage"`
-	Forkable      bool   `json:"forkable"`
+	Slug          string          `json:"slug"`
+	ID            int             `json:"id"`
+	Name          string          `json:"name"`
+	ScmID         string          `json:"scmId"`
+	State         RepositoryState `json:"state"`
+	StatusMessage string          `json:"statusMessage"`
+	Forkable      bool            `json:"forkable"`
 	Project       `json:"project"`
 	Public        bool `json:"public"`
 }
 
 // Participant maps to the participant key of a Bitbucket event
-type Participant struct {
-	Actor              `json:"user"`
-	LastReviewedCommit string `json:"lastReviewedCommit"`
-	Role               string `json:"role"`
-	Approved           string `json:"approved"`
-	Status             string `json:"status"`
-}
+type Participant = PullRequestParticipant
 
 // PreviousTarget maps to the previousTarget key of a Bitbucket event
 type PreviousTarget struct {
-	ID              string `json:"id"`
-	DisplayID       string `json:"displayId"`
-	Type            string `json:"type"`
-	LatestCommit    string `json:"latestCommit"`
-	LatestChangeset string `json:"latestChangeset"`
+	ID              string  `json:"id"`
+	DisplayID       string  `json:"displayId"`
+	Type            RefType `json:"type"`
+	LatestCommit    string  `json:"latestCommit"`
+	LatestChangeset string  `json:"latestChangeset"`
 }
 
 // Comment maps to the `comment` key of a Bitbucket event
@@ -285,8 +456,48 @@ type Comment struct {
 	Version     uint   `json:"version"`
 	Text        string `json:"text"`
 	Actor       `json:"author"`
-	CreatedDate uint                     `json:"createdDate"`
-	UpdatedDate uint                     `json:"updatedDate"`
+	CreatedDate Timestamp                `json:"createdDate"`
+	UpdatedDate Timestamp                `json:"updatedDate"`
 	Comments    []Comment                `json:"comments"`
 	Tasks       []map[string]interface{} `json:"tasks"`
+
+	// Severity is either "NORMAL" or "BLOCKER". Blocker comments are shown as tasks in Bitbucket Data Center.
+	Severity string `json:"severity"`
+
+	// State is either "OPEN" or "RESOLVED"
+	State string `json:"state"`
+
+	// ThreadResolved is true when the comment thread has been resolved
+	ThreadResolved bool `json:"threadResolved"`
+
+	// Anchor is set when the comment was made on a file or line of a diff
+	Anchor *CommentAnchor `json:"anchor,omitempty"`
+}
+
+// CommentAnchor maps to the `anchor` key of a comment made on a file or line of a diff
+type CommentAnchor struct {
+	FromHash        string           `json:"fromHash"`
+	ToHash          string           `json:"toHash"`
+	Line            uint             `json:"line"`
+	LineType        string           `json:"lineType"`
+	FileType        string           `json:"fileType"`
+	Path            string           `json:"path"`
+	SrcPath         string           `json:"srcPath"`
+	DiffType        string           `json:"diffType"`
+	Orphaned        bool             `json:"orphaned"`
+	MultilineMarker *MultilineMarker `json:"multilineMarker,omitempty"`
+}
+
+// MultilineMarker maps to the `multilineMarker` key of a comment anchor spanning more than one line
+type MultilineMarker struct {
+	StartLine     uint   `json:"startLine"`
+	StartLineType string `json:"startLineType"`
+}
+
+// SecretFinding maps to the `secretFinding` key of a 'repo:secret_detected' event
+type SecretFinding struct {
+	RuleName   string `json:"ruleName"`
+	Path       string `json:"path"`
+	LineNumber uint   `json:"lineNumber"`
+	CommitID   string `json:"commitId"`
 }
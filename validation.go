@@ -0,0 +1,109 @@
+package bitbucket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the largest request body accepted by a Webhook unless WithMaxBodySize is set
+const DefaultMaxBodySize int64 = 10 << 20
+
+// WithMaxBodySize sets the largest request body in bytes that is read before a request is rejected with
+// ErrBodyTooLarge. When gzip decoding is enabled the limit applies to the decompressed body. A size of zero or less
+// removes the limit.
+func WithMaxBodySize(size int64) Option {
+	return func(w *Webhook) {
+		w.maxBodySize = size
+	}
+}
+
+// WithGzip enables transparent decoding of request bodies sent with a "Content-Encoding: gzip" header, as some
+// proxies and relays compress requests. The signature is verified against the decompressed body. Without this
+// option such requests are rejected with ErrContentEncoding.
+func WithGzip() Option {
+	return func(w *Webhook) {
+		w.gzip = true
+	}
+}
+
+// validateRequest checks the method and headers of a request before its body is read
+func (hook *Webhook) validateRequest(req *http.Request) *ParseError {
+	event := EventKey(req.Header.Get("X-Event-Key"))
+
+	if req.Method != http.MethodPost {
+		return &ParseError{EventKey: event, Status: http.StatusMethodNotAllowed, Err: fmt.Errorf("%w: %s", ErrMethodNotAllowed, req.Method)}
+	}
+
+	// Requests without a Content-Type are accepted, as not every relay sets one
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			return &ParseError{EventKey: event, Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("%w: %s", ErrContentType, contentType)}
+		}
+	}
+
+	switch encoding := strings.ToLower(req.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		if !hook.gzip {
+			return &ParseError{EventKey: event, Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("%w: gzip decoding is not enabled", ErrContentEncoding)}
+		}
+	default:
+		return &ParseError{EventKey: event, Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("%w: %s", ErrContentEncoding, encoding)}
+	}
+
+	return nil
+}
+
+// readBody reads the body of a request, decompressing it when it is gzip encoded, and enforces the maximum body
+// size. When PreserveBody is set the body of the request is replaced with the decompressed payload.
+func (hook *Webhook) readBody(req *http.Request) ([]byte, *ParseError) {
+	event := EventKey(req.Header.Get("X-Event-Key"))
+
+	if hook.maxBodySize > 0 && req.ContentLength > hook.maxBodySize && req.Header.Get("Content-Encoding") == "" {
+		return nil, &ParseError{EventKey: event, Status: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrBodyTooLarge, req.ContentLength, hook.maxBodySize)}
+	}
+
+	var body io.Reader = req.Body
+	gzipped := strings.EqualFold(req.Header.Get("Content-Encoding"), "gzip")
+	if gzipped {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: fmt.Errorf("%w: %v", ErrContentEncoding, err)}
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	if hook.maxBodySize > 0 {
+		body = io.LimitReader(body, hook.maxBodySize+1)
+	}
+
+	payload, err := ioutil.ReadAll(body)
+	if err != nil {
+		if gzipped {
+			return nil, &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: fmt.Errorf("%w: %v", ErrContentEncoding, err)}
+		}
+		return nil, &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: fmt.Errorf("%w: %v", ErrReadingRequestBody, err)}
+	}
+
+	if hook.maxBodySize > 0 && int64(len(payload)) > hook.maxBodySize {
+		return nil, &ParseError{EventKey: event, Status: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("%w: body exceeds the limit of %d bytes", ErrBodyTooLarge, hook.maxBodySize)}
+	}
+
+	if hook.preserveRequestBody {
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(payload))
+		if gzipped {
+			req.Header.Del("Content-Encoding")
+			req.ContentLength = int64(len(payload))
+		}
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,135 @@
+package bitbucket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBody(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatalf("could not compress body: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not compress body: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRequestValidation(t *testing.T) {
+	const body = `{"eventKey": "pr:opened", "pullRequest": {"title": "Add validation"}}`
+	large := `{"eventKey": "pr:opened", "pullRequest": {"title": "` + strings.Repeat("a", 200) + `"}}`
+
+	tc := []struct {
+		Name            string
+		Options         []Option
+		Method          string
+		ContentType     string
+		ContentEncoding string
+		Body            io.Reader
+		ExpectedErr     error
+		ExpectedStatus  int
+	}{
+		{Name: "valid request", Body: strings.NewReader(body), ContentType: "application/json; charset=UTF-8"},
+		{Name: "request without a content type", Body: strings.NewReader(body)},
+		{Name: "GET request", Method: http.MethodGet, Body: strings.NewReader(body), ExpectedErr: ErrMethodNotAllowed, ExpectedStatus: http.StatusMethodNotAllowed},
+		{Name: "form content type", ContentType: "application/x-www-form-urlencoded", Body: strings.NewReader(body), ExpectedErr: ErrContentType, ExpectedStatus: http.StatusUnsupportedMediaType},
+		{Name: "invalid content type", ContentType: "json;;", Body: strings.NewReader(body), ExpectedErr: ErrContentType, ExpectedStatus: http.StatusUnsupportedMediaType},
+		{Name: "body larger than the limit", Options: []Option{WithMaxBodySize(100)}, Body: strings.NewReader(large), ExpectedErr: ErrBodyTooLarge, ExpectedStatus: http.StatusRequestEntityTooLarge},
+		{Name: "body larger than the limit without a content length", Options: []Option{WithMaxBodySize(100)}, Body: ioutil.NopCloser(strings.NewReader(large)), ExpectedErr: ErrBodyTooLarge, ExpectedStatus: http.StatusRequestEntityTooLarge},
+		{Name: "body without a limit", Options: []Option{WithMaxBodySize(0)}, Body: strings.NewReader(large)},
+		{Name: "gzip body", Options: []Option{WithGzip()}, ContentEncoding: "gzip", Body: bytes.NewReader(gzipBody(t, body))},
+		{Name: "gzip body without gzip decoding", ContentEncoding: "gzip", Body: bytes.NewReader(gzipBody(t, body)), ExpectedErr: ErrContentEncoding, ExpectedStatus: http.StatusUnsupportedMediaType},
+		{Name: "gzip body larger than the limit once decompressed", Options: []Option{WithGzip(), WithMaxBodySize(100)}, ContentEncoding: "gzip", Body: bytes.NewReader(gzipBody(t, large)), ExpectedErr: ErrBodyTooLarge, ExpectedStatus: http.StatusRequestEntityTooLarge},
+		{Name: "corrupt gzip body", Options: []Option{WithGzip()}, ContentEncoding: "gzip", Body: strings.NewReader(body), ExpectedErr: ErrContentEncoding, ExpectedStatus: http.StatusBadRequest},
+		{Name: "unsupported encoding", ContentEncoding: "br", Body: strings.NewReader(body), ExpectedErr: ErrContentEncoding, ExpectedStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		method := tt.Method
+		if method == "" {
+			method = http.MethodPost
+		}
+
+		req := httptest.NewRequest(method, "/", tt.Body)
+		req.Header.Set("X-Event-Key", "pr:opened")
+		if tt.ContentType != "" {
+			req.Header.Set("Content-Type", tt.ContentType)
+		}
+		if tt.ContentEncoding != "" {
+			req.Header.Set("Content-Encoding", tt.ContentEncoding)
+		}
+
+		event, err := New(tt.Options...).Parse(req)
+		if tt.ExpectedErr == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.Name, err)
+				continue
+			}
+			if _, ok := event.(PullRequestOpenedPayload); !ok {
+				t.Errorf("%s: Expected: %T, Got: %T", tt.Name, PullRequestOpenedPayload{}, event)
+			}
+			continue
+		}
+
+		if !errors.Is(err, tt.ExpectedErr) {
+			t.Errorf("%s: Expected: %v, Got: %v", tt.Name, tt.ExpectedErr, err)
+		}
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && parseErr.Status != tt.ExpectedStatus {
+			t.Errorf("%s: Expected status: %d, Got: %d", tt.Name, tt.ExpectedStatus, parseErr.Status)
+		}
+	}
+}
+
+func TestGzipSignature(t *testing.T) {
+	const (
+		secret = "secret"
+		body   = `{"eventKey": "pr:opened"}`
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBody(t, body)))
+	req.Header.Set("X-Event-Key", "pr:opened")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Hub-Signature", signature(secret, body))
+
+	hook := New(WithSecret(secret), WithGzip(), PreserveBody())
+	if _, err := hook.Parse(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	preserved, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(preserved) != body {
+		t.Errorf("Expected: %s, Got: %s", body, preserved)
+	}
+	if req.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected the Content-Encoding header to be removed, Got: %s", req.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestMethodNotAllowedResponse(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	New().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected: %d, Got: %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("Expected: %s, Got: %s", http.MethodPost, rec.Header().Get("Allow"))
+	}
+}
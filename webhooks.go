@@ -3,30 +3,46 @@
 package bitbucket
 
 import (
-	"bytes"
+	"context"
 	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash"
-	"io/ioutil"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Event holds the Bitbucket Webhook event type
-type Event string
-
 // Option holds a webhook option
 type Option func(*Webhook)
 
 // Webhook is used to handle Bitbucket webhook events
 type Webhook struct {
-	secret                string
-	preserveRequestBody   bool
-	disableHMACValidation bool
+	secret               string
+	secrets              []Secret
+	secretProvider       SecretProvider
+	preserveRequestBody  bool
+	signaturePolicy      SignaturePolicy
+	algorithms           []string
+	strictDecoding       bool
+	unknownFieldReporter UnknownFieldReporter
+	deliveryStore        DeliveryStore
+	replayTTL            time.Duration
+	maxAge               time.Duration
+	idempotencyStore     IdempotencyStore
+	logger               Logger
+	maxBodySize          int64
+	gzip                 bool
+
+	mu            sync.RWMutex
+	handlers      map[EventKey][]EventHandler
+	middleware    []Middleware
+	keyMiddleware map[EventKey][]Middleware
+
+	deliveryLocks keyLocks
 }
 
 // New creates a new Webhook with default settings. The default Webhook does not set a Webhook Secret and
@@ -35,15 +51,47 @@ type Webhook struct {
 //
 // Options:
 // - WithSecret("WEBHOOK_SECRET")
+// - WithSecrets(Secret{ID: "2024-01", Value: "OLD_SECRET"}, Secret{ID: "2024-06", Value: "NEW_SECRET"})
+// - WithSecretProvider(NewDirSecretProvider("/etc/bitbucket/secrets"))
 // - PreserveBody()
 // - WithoutHMAC()
+// - WithSignaturePolicy(SignatureRequired)
+// - WithSignatureAlgorithms(AlgorithmSHA256, AlgorithmSHA512)
+// - WithStrictDecoding()
+// - WithUnknownFieldReporter(func(event EventKey, path string))
+// - WithReplayProtection(NewMemoryDeliveryStore(10000), 24*time.Hour)
+// - WithMaxAge(time.Hour)
+// - WithIdempotency(NewMemoryIdempotencyStore(24*time.Hour))
+// - WithLogger(slog.Default())
+// - WithMaxBodySize(1 << 20)
+// - WithGzip()
 //
 // WithSecret sets the webhook secret that is used as a key when validating a Bitbucket HMAC signature.
 //
+// WithSecrets sets multiple accepted secrets, which is used while a secret is being rotated.
+//
+// WithSecretProvider looks secrets up for each request, allowing a different secret per project or repository.
+//
 // PreserveBody preserves the *http.Request body after being read by a webhook.
 //
 // WithoutHMAC disables HMAC validation. When set to true, the X-Hub-Signature will not be validated. This should not be used in production environments.
 //
+// WithSignaturePolicy sets whether requests must be signed. By default a signature is required when a secret is set.
+//
+// WithSignatureAlgorithms sets the accepted signature algorithms. By default only sha256 is accepted.
+//
+// WithReplayProtection rejects deliveries that have already been accepted.
+//
+// WithMaxAge rejects events that are older than a maximum age.
+//
+// WithIdempotency skips handlers that already completed when a delivery is received again.
+//
+// WithLogger sets a structured logger. By default nothing is logged.
+//
+// WithMaxBodySize sets the largest accepted request body. By default it is DefaultMaxBodySize.
+//
+// WithGzip decodes gzip encoded request bodies.
+//
 // Example 1: Default Webhook
 //  webhook.New()
 //
@@ -52,19 +100,31 @@ type Webhook struct {
 //
 func New(options ...Option) *Webhook {
 	const (
-		defaultPreserveRequestBody   = false
-		defaultDisableHMACValidation = false
+		defaultPreserveRequestBody = false
 	)
 
 	w := &Webhook{
-		preserveRequestBody:   defaultPreserveRequestBody,
-		disableHMACValidation: defaultDisableHMACValidation,
+		preserveRequestBody: defaultPreserveRequestBody,
+		handlers:            make(map[EventKey][]EventHandler),
+		logger:              nopLogger{},
+		maxBodySize:         DefaultMaxBodySize,
 	}
 
 	for _, opt := range options {
 		opt(w)
 	}
 
+	if w.logger == nil {
+		w.logger = nopLogger{}
+	}
+
+	if w.signaturePolicy == 0 {
+		w.signaturePolicy = SignatureOptional
+		if len(w.configuredSecrets()) > 0 || w.secretProvider != nil {
+			w.signaturePolicy = SignatureRequired
+		}
+	}
+
 	return w
 }
 
@@ -85,149 +145,356 @@ func PreserveBody() Option {
 
 // WithoutHMAC diables HMAC Signature validation. All incoming events should be validated using their included
 // HMAC signature, when included in a X-Hub-Signature header. By disabling this check an event may come from an untrusted source
-// or have been modified onroute.
+// or have been modified onroute. It is the same as WithSignaturePolicy(SignatureDisabled).
 func WithoutHMAC() Option {
+	return WithSignaturePolicy(SignatureDisabled)
+}
+
+// SignaturePolicy controls how Parse authenticates requests using the X-Hub-Signature header
+type SignaturePolicy int
+
+const (
+	// SignatureRequired rejects requests that do not have a valid signature. This is the default when a secret is set.
+	SignatureRequired SignaturePolicy = iota + 1
+	// SignatureOptional validates the signature of requests that have one and accepts unsigned requests. This is
+	// the default when no secret is set.
+	SignatureOptional
+	// SignatureDisabled never validates signatures. This should not be used in production environments.
+	SignatureDisabled
+)
+
+// WithSignaturePolicy sets how requests are authenticated. When the policy is SignatureRequired, a secret must also be set
+// or every request is rejected.
+func WithSignaturePolicy(policy SignaturePolicy) Option {
+	return func(w *Webhook) {
+		w.signaturePolicy = policy
+	}
+}
+
+// UnknownFieldReporter is called with the event key and JSON path of every payload field that is not mapped by
+// the payload types of this package
+type UnknownFieldReporter func(event EventKey, path string)
+
+// WithStrictDecoding causes Parse to return an error when a payload contains fields that are not mapped by the
+// payload types of this package. The error lists the JSON paths of the unknown fields.
+func WithStrictDecoding() Option {
 	return func(w *Webhook) {
-		w.disableHMACValidation = true
+		w.strictDecoding = true
+	}
+}
+
+// WithUnknownFieldReporter sets a function that is called for every payload field not mapped by the payload types of
+// this package. Unlike WithStrictDecoding, the event is still parsed. Use it to notice when Bitbucket upgrades add
+// fields that should be added to this package.
+func WithUnknownFieldReporter(reporter UnknownFieldReporter) Option {
+	return func(w *Webhook) {
+		w.unknownFieldReporter = reporter
 	}
 }
 
 // Parse an Bitbucket Webhook request and return a matching struct. The HMAC signature of the request will be validated
-// when the 'X-Hub-Signature' header key is set.
-func (hook *Webhook) Parse(req *http.Request) (interface{}, error) {
+// when the 'X-Hub-Signature' header key is set. The returned Event is one of the payload types defined by this package.
+func (hook *Webhook) Parse(req *http.Request) (Event, error) {
+	delivery, err := hook.ParseDelivery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery.Event, nil
+}
+
+// ParseDelivery parses a Bitbucket Webhook request like Parse, but returns the parsed event together with the raw
+// JSON payload and the HTTP metadata of the request. The raw payload is kept even when PreserveBody is not set.
+func (hook *Webhook) ParseDelivery(req *http.Request) (*Delivery, error) {
+	receivedAt := time.Now()
+
+	event := EventKey(req.Header.Get("X-Event-Key"))
+	hook.logger.Debug("webhook request received",
+		"event_key", event,
+		"request_id", req.Header.Get("X-Request-Id"),
+		"user_agent", req.UserAgent(),
+		"remote_addr", req.RemoteAddr,
+		"header", redactHeader(req.Header),
+	)
+
+	if err := hook.validateRequest(req); err != nil {
+		return nil, hook.reject(req, "webhook request rejected", err)
+	}
 
-	event := Event(req.Header.Get("X-Event-Key"))
 	if event == "" {
-		return nil, fmt.Errorf("'%s' is not a valid event type", event)
+		return nil, hook.reject(req, "webhook request rejected", &ParseError{Status: http.StatusBadRequest, Err: fmt.Errorf("%w: missing X-Event-Key header", ErrEventType)})
+	}
+
+	payload, parseErr := hook.readBody(req)
+	if parseErr != nil {
+		return nil, hook.reject(req, "webhook request rejected", parseErr)
+	}
+
+	secret, err := hook.authenticate(req.Context(), req.Header, payload)
+	if err != nil {
+		return nil, hook.reject(req, "signature verification failed", &ParseError{EventKey: event, Status: authenticationStatus(err), Err: fmt.Errorf("could not validate signature: %w", err)})
+	}
+	if secret.Value != "" {
+		hook.logger.Debug("signature verified", "event_key", event, "request_id", req.Header.Get("X-Request-Id"), "secret_id", secret.ID)
+	} else {
+		hook.logger.Debug("signature not verified", "event_key", event, "request_id", req.Header.Get("X-Request-Id"), "policy", hook.signaturePolicy)
+	}
+
+	if event == EventDiagnosticsPing {
+		delivery := newDelivery(req, DiagnosticPingEvent{Test: true}, payload, receivedAt)
+		delivery.SecretID = secret.ID
+		return delivery, nil
+	}
+
+	if len(payload) == 0 {
+		return nil, hook.reject(req, "webhook request rejected", &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: fmt.Errorf("%w: empty body", ErrReadingRequestBody)})
 	}
 
-	fmt.Println(req.Header)
-	if event == "diagnostics:ping" {
-		return DiagnosticPingEvent{Test: true}, nil
+	pl, err := decodeEvent(event, payload)
+	if err != nil {
+		if !errors.Is(err, ErrEventType) {
+			err = fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		return nil, hook.reject(req, "could not decode payload", &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: err})
 	}
 
-	payload, err := ioutil.ReadAll(req.Body)
-	if err != nil || len(payload) == 0 {
-		return nil, fmt.Errorf("could not read request body: %w", err)
+	if hook.strictDecoding || hook.unknownFieldReporter != nil {
+		if err := hook.checkUnknownFields(event, payload, pl); err != nil {
+			return nil, hook.reject(req, "could not decode payload", &ParseError{EventKey: event, Status: http.StatusBadRequest, Err: err})
+		}
 	}
 
-	if hook.preserveRequestBody {
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(payload))
+	delivery := newDelivery(req, pl, payload, receivedAt)
+	delivery.SecretID = secret.ID
+
+	if err := hook.checkReplay(req.Context(), delivery); err != nil {
+		return nil, hook.reject(req, "webhook request rejected", &ParseError{EventKey: event, Status: replayStatus(err), Err: err})
 	}
 
-	if err := hook.VerifySignature(payload, req.Header.Get("X-Hub-Signature"), hook.secret); err != nil {
-		return nil, fmt.Errorf("could not validate signature: %w", err)
+	return delivery, nil
+}
+
+// reject logs why a request was rejected and returns err
+func (hook *Webhook) reject(req *http.Request, msg string, err *ParseError) error {
+	log := hook.logger.Warn
+	if err.Status >= http.StatusInternalServerError {
+		log = hook.logger.Error
 	}
 
+	log(msg,
+		"event_key", err.EventKey,
+		"request_id", req.Header.Get("X-Request-Id"),
+		"status", err.Status,
+		"error", err.Err,
+	)
+
+	return err
+}
+
+// authenticationStatus returns the HTTP status for an error returned by authenticate. Requests that are not signed
+// with an accepted secret are unauthorized, while a failure to look secrets up is an error of the server.
+func authenticationStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrMissingSignature), errors.Is(err, ErrMissingSecret), errors.Is(err, ErrInvalidSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrReadingRequestBody), errors.Is(err, ErrInvalidPayload):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// checkUnknownFields reports the fields of payload that are not mapped by the type of pl, and returns an error when
+// strict decoding is enabled and unknown fields were found
+func (hook *Webhook) checkUnknownFields(event EventKey, payload []byte, pl Event) error {
+	paths, err := unknownFields(payload, reflect.TypeOf(pl))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	if hook.unknownFieldReporter != nil {
+		for _, path := range paths {
+			hook.unknownFieldReporter(event, path)
+		}
+	}
+
+	if hook.strictDecoding && len(paths) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownField, strings.Join(paths, ", "))
+	}
+
+	return nil
+}
+
+// decodeEvent unmarshals payload into the payload type matching the event key
+func decodeEvent(event EventKey, payload []byte) (Event, error) {
 	switch event {
-	case "pr:opened":
+	case EventPullRequestOpened:
 		var pl PullRequestOpenedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:declined":
+	case EventPullRequestModified:
+		var pl PullRequestModifiedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
+	case EventPullRequestFromRefUpdated:
+		var pl FromRefUpdatedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
+	case EventPullRequestMerged:
+		var pl PullRequestMergedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
+	case EventPullRequestDeclined:
 		var pl PullRequestDeclinedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:deleted":
+	case EventPullRequestDeleted:
 		var pl PullRequestDeletedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:comment:added":
+	case EventPullRequestCommentAdded:
 		var pl PullRequestCommentAddedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:comment:deleted":
+	case EventPullRequestCommentDeleted:
 		var pl PullRequestCommentDeletedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:comment:edited":
+	case EventPullRequestCommentEdited:
 		var pl PullRequestCommentEditedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:reviewer:updated":
+	case EventPullRequestReviewerUpdated:
 		var pl PullRequestReviewerUpdatedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "pr:reviewer:approved":
+	case EventPullRequestReviewerApproved:
 		fallthrough
-	case "pr:reviewer:unapproved":
+	case EventPullRequestReviewerUnapproved:
 		fallthrough
-	case "pr:reviewer:needs_work":
+	case EventPullRequestReviewerNeedsWork:
 		var pl PullRequestReviewerPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:refs_changed":
+	case EventRepoRefsChanged:
 		var pl RepoRefsChangedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:modified":
+	case EventRepoModified:
 		var pl RepoModifiedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:forked":
+	case EventRepoForked:
 		var pl RepoForkPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:comment:added":
+	case EventRepoCommentAdded:
 		var pl RepoCommentAddedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:comment:edited":
+	case EventRepoCommentEdited:
 		var pl RepoCommentEditedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "repo:comment:deleted":
+	case EventRepoCommentDeleted:
 		var pl RepoCommentDeletedPayload
 		err := json.Unmarshal(payload, &pl)
 		return pl, err
-	case "mirror:repo_synchronized":
-		return nil, fmt.Errorf("'%s' not implemented", event)
+	case EventRepoSecretDetected:
+		var pl RepoSecretDetectedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
+	case EventProjectModified:
+		var pl ProjectModifiedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
+	case EventMirrorRepoSynchronized:
+		var pl MirrorRepoSynchronizedPayload
+		err := json.Unmarshal(payload, &pl)
+		return pl, err
 	default:
-		return nil, fmt.Errorf("'%s' is not a valid Bitbucket Webhook event type", event)
+		return nil, fmt.Errorf("%w: '%s' is not a Bitbucket Webhook event key", ErrEventType, event)
 	}
 }
 
-// VerifySignature is used to check an HMAC signature of a Bitbucket webhook request
+// authenticate applies the signature policy of the webhook to a request and returns the secret that validated its
+// signature. The zero Secret is returned when the signature was not validated.
+func (hook *Webhook) authenticate(ctx context.Context, header http.Header, payload []byte) (Secret, error) {
+	encodedHash := header.Get("X-Hub-Signature")
+
+	switch hook.signaturePolicy {
+	case SignatureDisabled:
+		return Secret{}, nil
+	case SignatureOptional:
+		if encodedHash == "" {
+			return Secret{}, nil
+		}
+	}
+
+	secrets := hook.configuredSecrets()
+	if hook.secretProvider != nil && encodedHash != "" {
+		query, err := newSecretQuery(header, payload)
+		if err != nil {
+			return Secret{}, err
+		}
+		provided, err := hook.secretProvider.Secrets(ctx, query)
+		if err != nil {
+			return Secret{}, fmt.Errorf("%w: %v", ErrSecretLookup, err)
+		}
+		secrets = append(secrets, provided...)
+	}
+
+	return hook.VerifySignatureWithSecrets(payload, encodedHash, secrets)
+}
+
+// VerifySignature is used to check an HMAC signature of a Bitbucket webhook request. An error is returned when
+// encodedHash is empty.
 func (hook *Webhook) VerifySignature(payload []byte, encodedHash, secret string) error {
+	_, err := hook.VerifySignatureWithSecrets(payload, encodedHash, []Secret{{Value: secret}})
+	return err
+}
+
+// VerifySignatureWithSecrets is used to check an HMAC signature of a Bitbucket webhook request against a set of
+// secrets. Only secrets that are active at the current time are tried. The secret that matched the signature is
+// returned, so callers can track when a secret that is being rotated out is no longer used.
+func (hook *Webhook) VerifySignatureWithSecrets(payload []byte, encodedHash string, secrets []Secret) (Secret, error) {
 	if encodedHash == "" {
-		return nil
+		return Secret{}, ErrMissingSignature
 	}
 
-	if secret == "" && encodedHash != "" {
-		return errors.New("requires webhook secret to be set")
+	active := activeSecrets(secrets, time.Now())
+	if len(active) == 0 {
+		return Secret{}, ErrMissingSecret
 	}
 
 	if len(payload) == 0 {
-		return errors.New("payload cannot be empty")
+		return Secret{}, fmt.Errorf("%w: payload cannot be empty", ErrReadingRequestBody)
 	}
 
-	var hashFn func() hash.Hash
-	var messageMAC string
+	prefix := strings.SplitN(encodedHash, "=", 2)[0]
+	messageMAC := strings.TrimPrefix(encodedHash, prefix+"=")
 
-	if strings.HasPrefix(encodedHash, "sha256=") {
-		messageMAC = strings.TrimPrefix(encodedHash, "sha256=")
-		hashFn = sha256.New
-	} else {
-		prefix := strings.Split(encodedHash, "=")[0]
-		return fmt.Errorf("invalid hash prefix. Expected 'sha256=...', but got: %s", prefix)
+	hashFn, ok := signatureAlgorithm(prefix)
+	if !ok || !hook.allowsAlgorithm(prefix) {
+		return Secret{}, fmt.Errorf("%w: invalid hash prefix. Expected one of '%s', but got: %s", ErrInvalidSignature, strings.Join(hook.allowedAlgorithms(), "', '"), prefix)
 	}
 
 	messageMACBuf, err := hex.DecodeString(messageMAC)
 	if err != nil {
-		return fmt.Errorf("failed to decode message: %w", err)
-	}
-
-	mac := hmac.New(hashFn, []byte(secret))
-	_, err = mac.Write(payload)
-	if err != nil {
-		return fmt.Errorf("failed to write message as a MAC: %w", err)
+		return Secret{}, fmt.Errorf("%w: failed to decode message: %v", ErrInvalidSignature, err)
 	}
 
-	expectedMAC := mac.Sum(nil)
+	for _, secret := range active {
+		mac := hmac.New(hashFn, []byte(secret.Value))
+		_, err = mac.Write(payload)
+		if err != nil {
+			return Secret{}, fmt.Errorf("failed to write message as a MAC: %w", err)
+		}
 
-	if ok := hmac.Equal(messageMACBuf, expectedMAC); !ok {
-		return errors.New("HMAC signatures do not match")
+		if ok := hmac.Equal(messageMACBuf, mac.Sum(nil)); ok {
+			return secret, nil
+		}
 	}
 
-	return nil
+	return Secret{}, fmt.Errorf("%w: HMAC signatures do not match", ErrInvalidSignature)
 }
@@ -1,14 +1,20 @@
 package bitbucket
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -88,6 +94,17 @@ func TestParse(t *testing.T) {
 			ExpectedErr:  false,
 			Secret:       "i am groot test",
 			ExpectedType: PullRequestCommentEditedPayload{},
+			Header: map[string][]string{
+				"X-Event-Key":     {"pr:comment:edited"},
+				"X-Hub-Signature": {"sha256=e6905952e0bfe3002779ce79104c79983817ecdc51af06e339624b272ba6ff53"},
+			},
+		},
+		{
+			Name:         "invalid pr:comment:edited, secret set but signature missing",
+			Body:         NewPullRequestOpened(),
+			ExpectedErr:  true,
+			Secret:       "i am groot test",
+			ExpectedType: PullRequestCommentEditedPayload{},
 			Header: map[string][]string{
 				"X-Event-Key": {"pr:comment:edited"},
 			},
@@ -228,3 +245,299 @@ func NewPullRequestOpened() io.Reader {
 	_, _ = json.Marshal(jsonStr)
 	return strings.NewReader(jsonStr)
 }
+
+func TestParseFixtures(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Fixture  string
+		EventKey string
+		Check    func(event interface{}) error
+	}{
+		{
+			Name:     "pr:opened",
+			Fixture:  "testdata/pr_opened.json",
+			EventKey: "pr:opened",
+			Check: func(event interface{}) error {
+				pl, ok := event.(PullRequestOpenedPayload)
+				if !ok {
+					return fmt.Errorf("expected PullRequestOpenedPayload, got %T", event)
+				}
+				pr := pl.PullRequest
+				if pr.Description != "Retries failed payment requests with backoff.\n\nFixes PAY-42" || pr.Draft {
+					return fmt.Errorf("unexpected description/draft: %q/%v", pr.Description, pr.Draft)
+				}
+				if pr.Author.Name != "admin" || pr.Author.Role != RoleAuthor {
+					return fmt.Errorf("unexpected author: %+v", pr.Author)
+				}
+				if len(pr.Reviewers) != 2 || pr.Reviewers[1].Name != "lead" || pr.Reviewers[1].Status != StatusUnapproved {
+					return fmt.Errorf("unexpected reviewers: %+v", pr.Reviewers)
+				}
+				if pr.Properties.MergeResult == nil || pr.Properties.MergeResult.Outcome != "CLEAN" {
+					return fmt.Errorf("unexpected merge result: %+v", pr.Properties.MergeResult)
+				}
+				if len(pr.Links.Self) != 1 || pr.Links.Self[0].Href != "http://example.com:7990/projects/PROJ/repos/repository/pull-requests/1" {
+					return fmt.Errorf("unexpected links: %+v", pr.Links)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "pr:reviewer:approved",
+			Fixture:  "testdata/pr_reviewer_approved.json",
+			EventKey: "pr:reviewer:approved",
+			Check: func(event interface{}) error {
+				pl, ok := event.(PullRequestReviewerPayload)
+				if !ok {
+					return fmt.Errorf("expected PullRequestReviewerPayload, got %T", event)
+				}
+				if !pl.Participant.Approved || pl.Participant.Status != StatusApproved || pl.PreviousStatus != StatusUnapproved {
+					return fmt.Errorf("unexpected participant: %+v, previous status: %s", pl.Participant, pl.PreviousStatus)
+				}
+				pr := pl.PullRequest
+				if pr.Properties.OpenTaskCount != 2 || pr.Properties.ResolvedTaskCount != 1 || pr.Properties.CommentCount != 4 {
+					return fmt.Errorf("unexpected properties: %+v", pr.Properties)
+				}
+				if len(pr.Participants) != 1 || pr.Participants[0].Role != RoleParticipant {
+					return fmt.Errorf("unexpected participants: %+v", pr.Participants)
+				}
+				if pr.Reviewers[1].Status != StatusNeedsWork {
+					return fmt.Errorf("expected second reviewer to need work, got %s", pr.Reviewers[1].Status)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "pr:merged",
+			Fixture:  "testdata/pr_merged.json",
+			EventKey: "pr:merged",
+			Check: func(event interface{}) error {
+				pl, ok := event.(PullRequestMergedPayload)
+				if !ok {
+					return fmt.Errorf("expected PullRequestMergedPayload, got %T", event)
+				}
+				if pl.PullRequest.ID != 9 || pl.PullRequest.State != "MERGED" || !pl.PullRequest.Closed {
+					return fmt.Errorf("unexpected pull request: %+v", pl.PullRequest)
+				}
+				if pl.Actor.Name != "user" {
+					return fmt.Errorf("expected actor 'user', got '%s'", pl.Actor.Name)
+				}
+				if pl.PullRequest.ClosedDate.UnixNano() != 1505786290773*int64(time.Millisecond) {
+					return fmt.Errorf("unexpected closedDate: %s", pl.PullRequest.ClosedDate)
+				}
+				if pl.PullRequest.Properties.MergeCommit == nil || pl.PullRequest.Properties.MergeCommit.DisplayID != "7e48f426f0a" {
+					return fmt.Errorf("unexpected merge commit: %+v", pl.PullRequest.Properties.MergeCommit)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "pr:modified",
+			Fixture:  "testdata/pr_modified.json",
+			EventKey: "pr:modified",
+			Check: func(event interface{}) error {
+				pl, ok := event.(PullRequestModifiedPayload)
+				if !ok {
+					return fmt.Errorf("expected PullRequestModifiedPayload, got %T", event)
+				}
+				if pl.PreviousTitle != "A cool PR" || pl.PreviousDescription != "A neat description" {
+					return fmt.Errorf("unexpected previous title/description: %q/%q", pl.PreviousTitle, pl.PreviousDescription)
+				}
+				if pl.PreviousTarget.DisplayID != "a-branch" {
+					return fmt.Errorf("expected previous target 'a-branch', got '%s'", pl.PreviousTarget.DisplayID)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "pr:from_ref_updated",
+			Fixture:  "testdata/pr_from_ref_updated.json",
+			EventKey: "pr:from_ref_updated",
+			Check: func(event interface{}) error {
+				pl, ok := event.(FromRefUpdatedPayload)
+				if !ok {
+					return fmt.Errorf("expected FromRefUpdatedPayload, got %T", event)
+				}
+				if pl.PreviousFromHash != "99f3ea32043ba3ecaa28de6046b420de70257d80" {
+					return fmt.Errorf("unexpected previousFromHash: '%s'", pl.PreviousFromHash)
+				}
+				if pl.PullRequest.FromRef.LatestCommit != "aab847db0cf6bf1e6a2c1bbdf3b4eaee5e8f0a1c" {
+					return fmt.Errorf("unexpected fromRef latestCommit: '%s'", pl.PullRequest.FromRef.LatestCommit)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "mirror:repo_synchronized",
+			Fixture:  "testdata/mirror_repo_synchronized.json",
+			EventKey: "mirror:repo_synchronized",
+			Check: func(event interface{}) error {
+				pl, ok := event.(MirrorRepoSynchronizedPayload)
+				if !ok {
+					return fmt.Errorf("expected MirrorRepoSynchronizedPayload, got %T", event)
+				}
+				if pl.MirrorServer.ID != "B1LX-JD2C-7J4D-UX1E" || pl.SyncType != MirrorSyncIncremental || pl.RefLimitExceeded {
+					return fmt.Errorf("unexpected mirror fields: %+v %s %v", pl.MirrorServer, pl.SyncType, pl.RefLimitExceeded)
+				}
+				if len(pl.Changes) != 1 || pl.Changes[0].FromHash != "ecddabb624f6f5ba43816f5926e580a5f680a932" {
+					return fmt.Errorf("unexpected changes: %+v", pl.Changes)
+				}
+				if pl.Repository.Project.Key != "PROJ" {
+					return fmt.Errorf("expected project 'PROJ', got '%s'", pl.Repository.Project.Key)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "project:modified",
+			Fixture:  "testdata/project_modified.json",
+			EventKey: "project:modified",
+			Check: func(event interface{}) error {
+				pl, ok := event.(ProjectModifiedPayload)
+				if !ok {
+					return fmt.Errorf("expected ProjectModifiedPayload, got %T", event)
+				}
+				if pl.OldVersion.Name != "Project" || pl.NewVersion.Name != "Renamed Project" {
+					return fmt.Errorf("unexpected project names: '%s' -> '%s'", pl.OldVersion.Name, pl.NewVersion.Name)
+				}
+				if pl.NewVersion.Description != "A new description" {
+					return fmt.Errorf("unexpected description: '%s'", pl.NewVersion.Description)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "repo:secret_detected",
+			Fixture:  "testdata/repo_secret_detected.json",
+			EventKey: "repo:secret_detected",
+			Check: func(event interface{}) error {
+				pl, ok := event.(RepoSecretDetectedPayload)
+				if !ok {
+					return fmt.Errorf("expected RepoSecretDetectedPayload, got %T", event)
+				}
+				if pl.SecretFinding.RuleName != "AWS access key" || pl.SecretFinding.Path != "config/settings.yaml" || pl.SecretFinding.LineNumber != 17 {
+					return fmt.Errorf("unexpected secret finding: %+v", pl.SecretFinding)
+				}
+				if pl.Repository.Slug != "payments" {
+					return fmt.Errorf("expected repository 'payments', got '%s'", pl.Repository.Slug)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "pr:comment:added with anchor",
+			Fixture:  "testdata/pr_comment_added.json",
+			EventKey: "pr:comment:added",
+			Check: func(event interface{}) error {
+				pl, ok := event.(PullRequestCommentAddedPayload)
+				if !ok {
+					return fmt.Errorf("expected PullRequestCommentAddedPayload, got %T", event)
+				}
+				if pl.Comment.Severity != "BLOCKER" || pl.Comment.State != "OPEN" {
+					return fmt.Errorf("unexpected severity/state: %s/%s", pl.Comment.Severity, pl.Comment.State)
+				}
+				anchor := pl.Comment.Anchor
+				if anchor == nil || anchor.Path != "client/retry.go" || anchor.Line != 31 || anchor.LineType != "ADDED" {
+					return fmt.Errorf("unexpected anchor: %+v", anchor)
+				}
+				if anchor.MultilineMarker == nil || anchor.MultilineMarker.StartLine != 27 {
+					return fmt.Errorf("unexpected multiline marker: %+v", anchor.MultilineMarker)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tc {
+		fmt.Println("Test:", tt.Name)
+		body, err := ioutil.ReadFile(tt.Fixture)
+		if err != nil {
+			t.Fatalf("could not read fixture: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("X-Event-Key", tt.EventKey)
+
+		event, err := New().Parse(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.Name, err)
+			continue
+		}
+
+		if err := tt.Check(event); err != nil {
+			t.Errorf("%s: %v", tt.Name, err)
+		}
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	const (
+		body   = `{"eventKey": "pr:opened", "test": true}`
+		secret = "s3cret"
+	)
+
+	valid := signature(secret, body)
+	invalid := signature("wrong secret", body)
+
+	tc := []struct {
+		Policy      SignaturePolicy
+		Secret      string
+		Signature   string
+		ExpectedErr bool
+	}{
+		// Default policy: required when a secret is set, otherwise optional
+		{Policy: 0, Secret: secret, Signature: "", ExpectedErr: true},
+		{Policy: 0, Secret: secret, Signature: valid, ExpectedErr: false},
+		{Policy: 0, Secret: secret, Signature: invalid, ExpectedErr: true},
+		{Policy: 0, Secret: "", Signature: "", ExpectedErr: false},
+		{Policy: 0, Secret: "", Signature: valid, ExpectedErr: true},
+		{Policy: 0, Secret: "", Signature: invalid, ExpectedErr: true},
+
+		{Policy: SignatureRequired, Secret: secret, Signature: "", ExpectedErr: true},
+		{Policy: SignatureRequired, Secret: secret, Signature: valid, ExpectedErr: false},
+		{Policy: SignatureRequired, Secret: secret, Signature: invalid, ExpectedErr: true},
+		{Policy: SignatureRequired, Secret: "", Signature: "", ExpectedErr: true},
+		{Policy: SignatureRequired, Secret: "", Signature: valid, ExpectedErr: true},
+		{Policy: SignatureRequired, Secret: "", Signature: invalid, ExpectedErr: true},
+
+		{Policy: SignatureOptional, Secret: secret, Signature: "", ExpectedErr: false},
+		{Policy: SignatureOptional, Secret: secret, Signature: valid, ExpectedErr: false},
+		{Policy: SignatureOptional, Secret: secret, Signature: invalid, ExpectedErr: true},
+		{Policy: SignatureOptional, Secret: "", Signature: "", ExpectedErr: false},
+		{Policy: SignatureOptional, Secret: "", Signature: valid, ExpectedErr: true},
+		{Policy: SignatureOptional, Secret: "", Signature: invalid, ExpectedErr: true},
+
+		{Policy: SignatureDisabled, Secret: secret, Signature: "", ExpectedErr: false},
+		{Policy: SignatureDisabled, Secret: secret, Signature: valid, ExpectedErr: false},
+		{Policy: SignatureDisabled, Secret: secret, Signature: invalid, ExpectedErr: false},
+		{Policy: SignatureDisabled, Secret: "", Signature: "", ExpectedErr: false},
+		{Policy: SignatureDisabled, Secret: "", Signature: valid, ExpectedErr: false},
+		{Policy: SignatureDisabled, Secret: "", Signature: invalid, ExpectedErr: false},
+	}
+
+	for _, eventKey := range []string{"pr:opened", "diagnostics:ping"} {
+		for i, tt := range tc {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			req.Header.Set("X-Event-Key", eventKey)
+			if tt.Signature != "" {
+				req.Header.Set("X-Hub-Signature", tt.Signature)
+			}
+
+			options := []Option{WithSecret(tt.Secret)}
+			if tt.Policy != 0 {
+				options = append(options, WithSignaturePolicy(tt.Policy))
+			}
+
+			_, err := New(options...).Parse(req)
+			if tt.ExpectedErr && err == nil || !tt.ExpectedErr && err != nil {
+				t.Errorf("%s case %d (policy: %d, secret: %q, signature: %q): Expected error: %v, Got: %v",
+					eventKey, i, tt.Policy, tt.Secret, tt.Signature, tt.ExpectedErr, err)
+			}
+		}
+	}
+}
+
+func signature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}